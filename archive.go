@@ -0,0 +1,180 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveTime is the modification time of every archived file, so that
+// archives of the same files are identical
+var archiveTime = time.Unix(0, 0).UTC()
+
+// archiveWriter writes gzipped tarballs which only depend on the archived
+// files, their names and whether they are executable
+type archiveWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	gz := gzip.NewWriter(w)
+	return &archiveWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+// addFile archives data as regular file name
+func (a *archiveWriter) addFile(name string, data []byte) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(data)), Mode: 0644, ModTime: archiveTime}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+// addTree archives every file of dir except for git metadata in lexical
+// order under prefix, skipping files skip returns true for
+func (a *archiveWriter) addTree(prefix string, dir string, skip func(rel string) bool) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == ".git" && info.IsDir():
+			return filepath.SkipDir
+		case rel == "." || skip != nil && skip(rel):
+			return nil
+		}
+
+		header := &tar.Header{Name: path.Join(prefix, rel), Mode: 0644, ModTime: archiveTime}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			header.Typeflag, header.Linkname, header.Mode = tar.TypeSymlink, filepath.ToSlash(link), 0777
+		case info.IsDir():
+			header.Typeflag, header.Name, header.Mode = tar.TypeDir, header.Name+"/", 0755
+		case info.Mode().IsRegular():
+			header.Typeflag, header.Size = tar.TypeReg, info.Size()
+			if info.Mode()&0111 != 0 {
+				header.Mode = 0755
+			}
+		default:
+			return fmt.Errorf("can't archive special file %s", p)
+		}
+		if err := a.tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(a.tw, f)
+		return err
+	})
+}
+
+func (a *archiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// extractArchive extracts the gzipped tarball r into dir. Only regular
+// files, folders and symlinks are extracted, and nothing is written outside
+// of dir or through a symlink.
+func extractArchive(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	links := make(map[string]bool)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(header.Name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("archive entry %q points outside of the archive", header.Name)
+		}
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			if links[parent] {
+				return fmt.Errorf("archive entry %q is inside of symlink %s", header.Name, parent)
+			}
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeSymlink:
+			links[name] = true
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(filepath.FromSlash(header.Linkname), target)
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = extractFile(tr, target, os.FileMode(header.Mode).Perm()&0755)
+			}
+		default:
+			return fmt.Errorf("archive entry %q is not a regular file, folder or symlink", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bundleManifestFile describes the modules of a bundle, their content is in
+// bundleModulesDir
+const (
+	bundleManifestFile = "bundle.json"
+	bundleModulesDir   = "modules"
+)
+
+// bundleCommand groups commands working with module bundles
+type bundleCommand struct{}
+
+type bundleCreateCommand struct {
+	Args struct {
+		File string `positional-arg-name:"file" description:"Bundle to write, a gzipped tarball"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// bundleManifest lists the modules of a bundle with their lockfile entries
+type bundleManifest struct {
+	Profile string                  `json:"profile"`
+	Modules map[string]lockedModule `json:"modules"`
+}
+
+// moduleBundle is a bundle extracted to install modules from
+type moduleBundle struct {
+	filename string
+	dir      string
+	manifest bundleManifest
+	// locked are the lockfile entries of the active profile
+	locked map[string]lockedModule
+}
+
+// Execute packages the installed modules of the Terrafile, and the modules
+// they use, with their lockfile entries into a bundle
+func (c *bundleCreateCommand) Execute(_ []string) error {
+	allModules, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	config, err := selectModules(allModules)
+	if err != nil {
+		return err
+	}
+	if overrides := activeOverrides(config); len(overrides) > 0 {
+		return fmt.Errorf("refusing to bundle modules while overrides are active: %s", strings.Join(overrides, "; "))
+	}
+
+	// modules vendored by --transitive are installed already, so nothing is fetched
+	derived, err := installDependencies(config, allModules, func(map[string]module) {})
+	if err != nil {
+		return fmt.Errorf("failed to find dependencies of modules due to error: %s", err)
+	}
+	for key, m := range derived {
+		config[key] = m
+	}
+
+	lock, err := readLock(lockPath(opts.TerrafilePath))
+	if err != nil {
+		return err
+	}
+	manifest, err := bundledModules(config, lock.Profiles[activeProfile()])
+	if err != nil {
+		return err
+	}
+
+	if err := writeBundle(c.Args.File, config, manifest); err != nil {
+		return fmt.Errorf("failed to write bundle %s due to error: %s", c.Args.File, err)
+	}
+	log.Infof("[*] Bundled %d module(s) into %s", len(manifest.Modules), c.Args.File)
+
+	return nil
+}
+
+// bundledModules returns the manifest of a bundle of the modules of config,
+// which must be installed unmodified at the resolutions locked in locked
+func bundledModules(config map[string]module, locked map[string]lockedModule) (bundleManifest, error) {
+	manifest := bundleManifest{Profile: activeProfile(), Modules: make(map[string]lockedModule)}
+
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		cloneDestination, _ := moduleDestinations(m)
+		moduleDir := filepath.Join(cloneDestination, key)
+
+		entry, ok := locked[key]
+		if !ok || entry.Hash == "" {
+			return bundleManifest{}, fmt.Errorf("module %s has no hash in %s, install modules before bundling them", key, lockPath(opts.TerrafilePath))
+		}
+		if entry.Source != m.Source || entry.Version != m.Version {
+			return bundleManifest{}, fmt.Errorf("%s locks %s of %s for module %s, but the Terrafile wants %s of %s, install modules before bundling them",
+				lockPath(opts.TerrafilePath), entry.Version, entry.Source, key, m.Version, m.Source)
+		}
+
+		metadata, err := readMetadata(moduleDir)
+		if err != nil {
+			return bundleManifest{}, err
+		}
+		if metadata == nil || metadata.Commit != entry.Commit || metadata.Hash != entry.Hash {
+			return bundleManifest{}, fmt.Errorf("module %s installed at %s is not the one locked in %s, install modules before bundling them", key, moduleDir, lockPath(opts.TerrafilePath))
+		}
+		if _, hash, err := hashModule(moduleDir); err != nil || hash != entry.Hash {
+			return bundleManifest{}, fmt.Errorf("module %s installed at %s is modified, reinstall it before bundling it", key, moduleDir)
+		}
+
+		manifest.Modules[key] = entry
+	}
+
+	return manifest, nil
+}
+
+// writeBundle writes the bundle of modules of manifest installed for config
+// to filename. Bundles of the same modules are identical.
+func writeBundle(filename string, config map[string]module, manifest bundleManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// a failed bundle never replaces an existing one
+	f, err := os.CreateTemp(filepath.Dir(filename), ".terrafile-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	archive := newArchiveWriter(f)
+	if err := archive.addFile(bundleManifestFile, append(data, '\n')); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(config) {
+		cloneDestination, _ := moduleDestinations(config[key])
+		if err := archive.addTree(path.Join(bundleModulesDir, key), filepath.Join(cloneDestination, key), nil); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// openBundle extracts the bundle filename into a temporary folder, removed
+// with close
+func openBundle(filename string) (*moduleBundle, error) {
+	lock, err := readLock(lockPath(opts.TerrafilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir, err := os.MkdirTemp("", "terrafile-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	b := &moduleBundle{filename: filename, dir: dir, locked: lock.Profiles[activeProfile()]}
+
+	if err := extractArchive(f, dir); err != nil {
+		b.close()
+		return nil, fmt.Errorf("failed to extract bundle %s due to error: %s", filename, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if err == nil {
+		err = json.Unmarshal(data, &b.manifest)
+	}
+	if err != nil {
+		b.close()
+		return nil, fmt.Errorf("failed to read %s of bundle %s due to error: %s", bundleManifestFile, filename, err)
+	}
+	if b.manifest.Profile != activeProfile() {
+		log.Warnf("[*] Bundle %s was created for profile %s, installing profile %s from it", filename, b.manifest.Profile, activeProfile())
+	}
+
+	return b, nil
+}
+
+func (b *moduleBundle) close() {
+	if err := os.RemoveAll(b.dir); err != nil {
+		log.Errorf("failed to remove %s due to error: %s", b.dir, err)
+	}
+}
+
+// installModule installs module m into destinationDir/key from the bundle
+// like installModule, but only after verifying that the bundled module is
+// the one locked in the lockfile
+func (b *moduleBundle) installModule(key string, m module, destinationDir string) error {
+	lockfile := lockPath(opts.TerrafilePath)
+	entry, ok := b.locked[key]
+	switch {
+	case !ok || entry.Hash == "":
+		return fmt.Errorf("module %s has no hash in %s, it can't be verified", key, lockfile)
+	case entry.Source != m.Source || entry.Version != m.Version:
+		return fmt.Errorf("%s locks %s of %s, but the Terrafile wants %s of %s", lockfile, entry.Version, entry.Source, m.Version, m.Source)
+	}
+	if bundled, ok := b.manifest.Modules[key]; !ok {
+		return fmt.Errorf("module is not in bundle %s", b.filename)
+	} else if bundled.Source != entry.Source || bundled.Version != entry.Version || bundled.Commit != entry.Commit || bundled.Hash != entry.Hash {
+		return fmt.Errorf("bundle %s has %s of %s (%s), but %s locks %s of %s (%s)",
+			b.filename, bundled.Version, bundled.Source, shortCommit(bundled.Commit), lockfile, entry.Version, entry.Source, shortCommit(entry.Commit))
+	}
+
+	moduleDir := filepath.Join(destinationDir, key)
+	if metadata, err := readMetadata(moduleDir); err == nil && metadata != nil && metadata.Commit == entry.Commit && metadata.Hash == entry.Hash &&
+		metadata.Transitive == opts.Transitive {
+		if _, hash, err := hashModule(moduleDir); err == nil && hash == entry.Hash {
+			log.Infof("[*] %s is up to date at %s of %s (%s)", moduleDir, m.Version, m.Source, entry.Commit)
+			return nil
+		}
+	}
+
+	bundled := filepath.Join(b.dir, bundleModulesDir, key)
+	metadata, err := readMetadata(bundled)
+	if err != nil {
+		return err
+	}
+	if metadata == nil || metadata.Source != entry.Source || metadata.Version != entry.Version || metadata.Commit != entry.Commit {
+		return fmt.Errorf("metadata of the module in bundle %s doesn't match %s", b.filename, lockfile)
+	}
+	if strings.Join(metadata.Filters, "\n") != strings.Join(moduleFilters(m), "\n") {
+		return fmt.Errorf("module was bundled with other include or exclude patterns than the Terrafile has")
+	}
+	if metadata.Transitive != opts.Transitive {
+		return fmt.Errorf("module was bundled with --transitive=%t, install it with the same setting", metadata.Transitive)
+	}
+
+	staging, err := os.MkdirTemp(destinationDir, ".terrafile-"+key+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	staged := filepath.Join(staging, key)
+	if err := copyTree(bundled, staged); err != nil {
+		return err
+	}
+	if _, hash, err := hashModule(staged); err != nil {
+		return err
+	} else if hash != entry.Hash {
+		return fmt.Errorf("content of the module in bundle %s doesn't match hash %s of %s", b.filename, entry.Hash, lockfile)
+	}
+
+	// bundles are checked just like fetched modules
+	report, err := scanModule(staged)
+	if err != nil {
+		return fmt.Errorf("failed to check content due to error: %s", err)
+	}
+	report.log(key)
+	if report.rejected() {
+		return fmt.Errorf("module was rejected due to its content")
+	}
+
+	log.Infof("[*] Installing %s of %s (%s) from bundle %s", m.Version, m.Source, shortCommit(entry.Commit), b.filename)
+	if err := os.RemoveAll(moduleDir); err != nil {
+		return err
+	}
+
+	return os.Rename(staged, moduleDir)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	source := createGitRepository(t, "v1.0.0")
+	back := chdir(t, t.TempDir())
+	defer back()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	opts.TerrafilePath = "Terrafile"
+	createFile(t, "Terrafile", "vpc:\n  source: \""+source+"\"\n  version: \"v1.0.0\"\n")
+	config, err := loadTerrafile()
+	assert.NoError(t, err)
+
+	// modules must be installed and locked first
+	create := &bundleCreateCommand{}
+	create.Args.File = "out.tar.gz"
+	assert.Error(t, create.Execute(nil))
+
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	installModules(config, wd, nil)
+	assert.NoError(t, recordLock(config, config))
+
+	assert.NoError(t, create.Execute(nil))
+	first, err := os.ReadFile("out.tar.gz")
+	assert.NoError(t, err)
+	assert.NoError(t, create.Execute(nil))
+	second, err := os.ReadFile("out.tar.gz")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(first, second), "bundles of the same modules differ")
+
+	// installed without access to the repository
+	assert.NoError(t, os.RemoveAll(opts.ModulePath))
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	assert.NoError(t, os.RemoveAll(filepath.FromSlash(source[len("file://"):])))
+	bundle, err := openBundle("out.tar.gz")
+	assert.NoError(t, err)
+	defer bundle.close()
+
+	assert.NoError(t, bundle.installModule("vpc", config["vpc"], opts.ModulePath))
+	assert.FileExists(t, "vendor/modules/vpc/main.tf")
+	statuses := moduleStatuses(config)
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, stateOK, statuses[0].State)
+
+	// anything not matching the lockfile is refused
+	m := config["vpc"]
+	m.Version = "v2.0.0"
+	assert.Error(t, bundle.installModule("vpc", m, opts.ModulePath))
+
+	locked := bundle.locked["vpc"]
+	bundle.locked["vpc"] = lockedModule{Source: locked.Source, Version: locked.Version, Commit: locked.Commit, Hash: "sha256:0"}
+	assert.Error(t, bundle.installModule("vpc", config["vpc"], opts.ModulePath))
+	bundle.locked["vpc"] = locked
+
+	createFile(t, filepath.Join(bundle.dir, bundleModulesDir, "vpc", "main.tf"), "# tampered\n")
+	assert.NoError(t, os.RemoveAll(opts.ModulePath))
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	assert.Error(t, bundle.installModule("vpc", config["vpc"], opts.ModulePath))
+	assert.NoFileExists(t, "vendor/modules/vpc/main.tf")
+}
+
+func TestParseInstallFromBundle(t *testing.T) {
+	defer restoreOpts()()
+
+	p := newParser()
+	var executed flags.Commander
+	p.CommandHandler = func(command flags.Commander, args []string) error {
+		executed = command
+		return nil
+	}
+	_, err := p.ParseArgs([]string{"install", "--from-bundle", "out.tar.gz", "--module_path", "modules"})
+	assert.NoError(t, err)
+	if assert.IsType(t, &installCommand{}, executed) {
+		assert.Equal(t, "out.tar.gz", executed.(*installCommand).FromBundle)
+	}
+	assert.Equal(t, "modules", opts.ModulePath)
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	dir := t.TempDir()
+	for _, files := range []map[string]string{
+		{"../outside": ""},
+		{"/etc/outside": ""},
+	} {
+		var archive bytes.Buffer
+		w := newArchiveWriter(&archive)
+		for name, data := range files {
+			assert.NoError(t, w.addFile(name, []byte(data)))
+		}
+		assert.NoError(t, w.Close())
+		assert.Error(t, extractArchive(&archive, filepath.Join(dir, "out")))
+	}
+
+	// nothing is written through symlinks
+	src := t.TempDir()
+	assert.NoError(t, os.Symlink(dir, filepath.Join(src, "link")))
+	var archive bytes.Buffer
+	w := newArchiveWriter(&archive)
+	assert.NoError(t, w.addTree("", src, nil))
+	assert.NoError(t, w.addFile("link/outside", nil))
+	assert.NoError(t, w.Close())
+	assert.Error(t, extractArchive(&archive, filepath.Join(dir, "out")))
+	assert.NoFileExists(t, filepath.Join(dir, "outside"))
+}
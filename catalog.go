@@ -0,0 +1,138 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// catalogVersion is the version of modules taking their version from the catalog
+const catalogVersion = "catalog"
+
+// versionCatalog maps module sources to their approved versions
+type versionCatalog struct {
+	origin   string
+	versions map[string]string
+}
+
+// approved returns the version of source approved by the catalog
+func (c *versionCatalog) approved(source string) (string, bool) {
+	version, ok := c.versions[catalogKey(source)]
+	return version, ok
+}
+
+// catalogKey normalizes source, so that the same repository written with or
+// without a trailing slash or `.git` suffix is the same entry
+func catalogKey(source string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(source, "/"), ".git")
+}
+
+// loadCatalog reads the catalog ref of the file at location points to
+func loadCatalog(location terrafileLocation, ref fileReference) (*versionCatalog, []error) {
+	catalogLocation, cleanup, err := resolveReference(location, ref, "Terrafile.catalog")
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer cleanup()
+
+	origin := catalogLocation.origin()
+	data, err := os.ReadFile(catalogLocation.file)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read catalog %s due to error: %s", origin, err)}
+	}
+
+	return parseCatalog(origin, data)
+}
+
+// parseCatalog strictly decodes contents of the catalog named origin, a
+// mapping of module sources to versions
+func parseCatalog(origin string, data []byte) (*versionCatalog, []error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, []error{validationError{File: origin, Message: err.Error()}}
+	}
+
+	catalog := &versionCatalog{origin: origin, versions: make(map[string]string)}
+	if len(root.Content) == 0 {
+		return catalog, nil
+	}
+
+	v := validator{file: origin}
+	node := root.Content[0]
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping of module sources to versions")
+		return nil, v.errs
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		sourceNode, versionNode := node.Content[i], node.Content[i+1]
+		source := catalogKey(sourceNode.Value)
+
+		if line, ok := seen[source]; ok {
+			v.errorf(sourceNode, "duplicate source %q, first defined on line %d", sourceNode.Value, line)
+			continue
+		}
+		seen[source] = sourceNode.Line
+
+		if versionNode.Kind != yaml.ScalarNode || strings.TrimSpace(versionNode.Value) == "" || versionNode.Value == catalogVersion {
+			v.errorf(versionNode, "version of %q must be a non-empty string", sourceNode.Value)
+			continue
+		}
+		catalog.versions[source] = versionNode.Value
+	}
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	return catalog, nil
+}
+
+// resolveVersions sets version of modules of config without one, or with
+// version `catalog`, to the version approved by catalog. Modules pinning a
+// version different from the approved one must be marked with `override: true`.
+func resolveVersions(config map[string]module, catalog *versionCatalog) []error {
+	var errs []error
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		fromCatalog := m.Version == "" || m.Version == catalogVersion
+
+		if catalog == nil {
+			if fromCatalog {
+				errs = append(errs, fmt.Errorf("%s: module %q has no version and there is no catalog to take it from", m.Origin, key))
+			}
+			continue
+		}
+
+		approved, ok := catalog.approved(m.Source)
+		switch {
+		case fromCatalog && !ok:
+			errs = append(errs, fmt.Errorf("%s: module %q takes its version from catalog %s, which has no version of %s", m.Origin, key, catalog.origin, m.Source))
+		case fromCatalog:
+			m.Version = approved
+			config[key] = m
+		case ok && m.Version != approved && !m.Override:
+			errs = append(errs, fmt.Errorf("%s: module %q pins %s of %s, but catalog %s approves %s, set `override: true` to pin a different version", m.Origin, key, m.Version, m.Source, catalog.origin, approved))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCatalog(t *testing.T) {
+	catalog, errs := parseCatalog("Terrafile.catalog", []byte(`"git@github.com:terraform-aws-modules/terraform-aws-vpc.git": v1.46.0
+"git@github.com:terraform-aws-modules/terraform-aws-iam": v1.0.0
+`))
+	assert.Empty(t, errs)
+	version, ok := catalog.approved("git@github.com:terraform-aws-modules/terraform-aws-vpc")
+	assert.True(t, ok)
+	assert.Equal(t, "v1.46.0", version)
+
+	_, errs = parseCatalog("Terrafile.catalog", []byte(`"git@github.com:terraform-aws-modules/terraform-aws-vpc": v1.46.0
+"git@github.com:terraform-aws-modules/terraform-aws-vpc/": v2.0.0
+"git@github.com:terraform-aws-modules/terraform-aws-iam": ""
+`))
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		`Terrafile.catalog:2:1: duplicate source "git@github.com:terraform-aws-modules/terraform-aws-vpc/", first defined on line 1`,
+		`Terrafile.catalog:3:59: version of "git@github.com:terraform-aws-modules/terraform-aws-iam" must be a non-empty string`,
+	}, messages)
+}
+
+func TestResolveVersions(t *testing.T) {
+	catalog := &versionCatalog{origin: "Terrafile.catalog", versions: map[string]string{
+		"git@github.com:terraform-aws-modules/terraform-aws-vpc": "v1.46.0",
+	}}
+	vpc := "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+
+	config := map[string]module{
+		"omitted": {Source: vpc, Origin: "Terrafile"},
+		"catalog": {Source: vpc, Version: "catalog", Origin: "Terrafile"},
+		"same":    {Source: vpc, Version: "v1.46.0", Origin: "Terrafile"},
+		"pinned":  {Source: vpc, Version: "v2.0.0", Override: true, Origin: "Terrafile"},
+		"other":   {Source: "git@github.com:terraform-aws-modules/terraform-aws-iam", Version: "v1.0.0", Origin: "Terrafile"},
+	}
+	assert.Empty(t, resolveVersions(config, catalog))
+	for key, expected := range map[string]string{"omitted": "v1.46.0", "catalog": "v1.46.0", "same": "v1.46.0", "pinned": "v2.0.0", "other": "v1.0.0"} {
+		assert.Equal(t, expected, config[key].Version, key)
+	}
+
+	errs := resolveVersions(map[string]module{
+		"pinned":  {Source: vpc, Version: "v2.0.0", Origin: "Terrafile"},
+		"missing": {Source: "git@github.com:terraform-aws-modules/terraform-aws-iam", Origin: "Terrafile"},
+	}, catalog)
+	if assert.Len(t, errs, 2) {
+		assert.EqualError(t, errs[0], `Terrafile: module "missing" takes its version from catalog Terrafile.catalog, which has no version of git@github.com:terraform-aws-modules/terraform-aws-iam`)
+		assert.EqualError(t, errs[1], `Terrafile: module "pinned" pins v2.0.0 of `+vpc+`, but catalog Terrafile.catalog approves v1.46.0, set `+"`override: true`"+` to pin a different version`)
+	}
+
+	errs = resolveVersions(map[string]module{"omitted": {Source: vpc, Origin: "Terrafile"}}, nil)
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], `Terrafile: module "omitted" has no version and there is no catalog to take it from`)
+	}
+}
+
+func TestLoadTerrafileCatalog(t *testing.T) {
+	defer restoreOpts()()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+	opts.TerrafilePath = "Terrafile"
+
+	createFile(t, "Terrafile.catalog", `"git@github.com:terraform-aws-modules/terraform-aws-vpc": v1.46.0`)
+	createFile(t, "platform.Terrafile", `tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: catalog
+`)
+	createFile(t, "Terrafile", `terrafile:
+  catalog: Terrafile.catalog
+  include:
+    - platform.Terrafile
+`)
+
+	// included files inherit the catalog of the including one
+	config, err := loadTerrafile()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.46.0", config["tf-aws-vpc"].Version)
+}
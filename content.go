@@ -0,0 +1,214 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions taken on problematic files found in fetched modules
+const (
+	contentAllow  = "allow"
+	contentStrip  = "strip"
+	contentReject = "reject"
+)
+
+// problemExecutable is reported for files with executable bits set
+const problemExecutable = "executable file"
+
+// contentFinding is a problematic file found in a fetched module
+type contentFinding struct {
+	Path    string
+	Problem string
+	Action  string
+}
+
+// contentReport summarizes the content of a fetched module
+type contentReport struct {
+	Files    int
+	Size     int64
+	Findings []contentFinding
+}
+
+// rejected tells whether any finding of the report rejects the module
+func (r contentReport) rejected() bool {
+	for _, finding := range r.Findings {
+		if finding.Action == contentReject {
+			return true
+		}
+	}
+	return false
+}
+
+// log prints the report of module key
+func (r contentReport) log(key string) {
+	log.Infof("[*] Content of %s: %d file(s), %d byte(s), %d finding(s)", key, r.Files, r.Size, len(r.Findings))
+	for _, finding := range r.Findings {
+		if finding.Action == contentReject {
+			log.Errorf("[*]     rejected %s: %s", finding.Path, finding.Problem)
+			continue
+		}
+		log.Warnf("[*]     %s %s: %s", finding.Action, finding.Path, finding.Problem)
+	}
+}
+
+// scanModule checks content of the module fetched into dir for files which
+// are dangerous to place into stacks. Depending on the configured actions the
+// files are stripped from the module or reject the module altogether.
+func scanModule(dir string) (contentReport, error) {
+	var report contentReport
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		// git metadata of the module itself
+		if rel == ".git" {
+			return filepath.SkipDir
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		problem, action := inspectFile(dir, path, info)
+		if problem != "" {
+			report.Findings = append(report.Findings, contentFinding{Path: rel, Problem: problem, Action: action})
+		}
+
+		switch {
+		case action == contentStrip && problem == problemExecutable:
+			// executables are kept, but lose their executable bits
+			if err := os.Chmod(path, info.Mode().Perm()&^0111); err != nil {
+				return err
+			}
+		case action == contentStrip:
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		report.Files++
+		report.Size += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if opts.ContentMaxFiles > 0 && report.Files > opts.ContentMaxFiles {
+		report.Findings = append(report.Findings, contentFinding{
+			Path:    ".",
+			Problem: fmt.Sprintf("module has %d files, more than the limit of %d", report.Files, opts.ContentMaxFiles),
+			Action:  contentReject,
+		})
+	}
+	if opts.ContentMaxSize > 0 && report.Size > opts.ContentMaxSize {
+		report.Findings = append(report.Findings, contentFinding{
+			Path:    ".",
+			Problem: fmt.Sprintf("module has %d bytes, more than the limit of %d", report.Size, opts.ContentMaxSize),
+			Action:  contentReject,
+		})
+	}
+
+	return report, nil
+}
+
+// inspectFile returns what is wrong with the file at path inside of module
+// root and the action to take on it, or an empty problem if file is fine.
+// Stripping an executable removes its executable bits, any other file is removed.
+func inspectFile(root string, path string, info fs.FileInfo) (problem string, action string) {
+	mode := info.Mode()
+
+	switch {
+	case info.Name() == ".git":
+		return "nested git repository", opts.ContentAction
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Sprintf("unreadable symlink: %s", err), opts.ContentAction
+		}
+		if escapes(root, path, target) {
+			return fmt.Sprintf("symlink to %s points outside of the module", target), opts.ContentAction
+		}
+	case mode&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0:
+		return fmt.Sprintf("special file of type %s", mode.Type()), opts.ContentAction
+	case mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != 0:
+		return "setuid, setgid or sticky bit set", opts.ContentAction
+	case mode.IsRegular() && opts.ContentMaxFileSize > 0 && info.Size() > opts.ContentMaxFileSize:
+		return fmt.Sprintf("file has %d bytes, more than the limit of %d", info.Size(), opts.ContentMaxFileSize), opts.ContentAction
+	case mode.IsRegular() && mode.Perm()&0111 != 0 && (opts.ContentExecutables == contentStrip || opts.ContentExecutables == contentReject):
+		return problemExecutable, opts.ContentExecutables
+	}
+
+	return "", ""
+}
+
+// escapes tells whether symlink at path with target points outside of root.
+// Targets are resolved, as links they pass through may lead elsewhere than
+// their text suggests.
+func escapes(root string, path string, target string) bool {
+	if filepath.IsAbs(target) || outside(root, filepath.Join(filepath.Dir(path), target)) {
+		return true
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return true
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		// a dangling link, resolve the folder its target would be in without
+		// cleaning the target first, which would drop .. after links
+		dir, file := filepath.Split(target)
+		if dir, err = filepath.EvalSymlinks(filepath.Dir(path) + string(filepath.Separator) + dir); err == nil {
+			resolved = filepath.Join(dir, file)
+		}
+	}
+	return err != nil || outside(resolvedRoot, resolved)
+}
+
+// outside tells whether path is outside of root
+func outside(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
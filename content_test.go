@@ -0,0 +1,138 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanModule(t *testing.T) {
+	defer restoreOpts()()
+	opts.ContentAction = contentReject
+	opts.ContentExecutables = contentAllow
+
+	// clean module with an internal symlink
+	dir := createDangerousModule(t, false)
+	report, err := scanModule(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.rejected())
+	assert.Equal(t, 3, report.Files)
+	assert.Empty(t, report.Findings)
+
+	dir = createDangerousModule(t, true)
+	report, err = scanModule(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.rejected())
+	assert.ElementsMatch(t, []string{"credentials", "examples/.git"}, findingPaths(report))
+	assert.FileExists(t, filepath.Join(dir, "examples/.git/config"))
+
+	opts.ContentAction = contentStrip
+	report, err = scanModule(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.rejected())
+	assert.ElementsMatch(t, []string{"credentials", "examples/.git"}, findingPaths(report))
+	assert.NoFileExists(t, filepath.Join(dir, "credentials"))
+	assert.NoDirExists(t, filepath.Join(dir, "examples/.git"))
+	// metadata of the module itself is left alone
+	assert.FileExists(t, filepath.Join(dir, ".git/HEAD"))
+}
+
+func TestScanModuleChainedSymlinks(t *testing.T) {
+	defer restoreOpts()()
+	opts.ContentAction = contentReject
+	opts.ContentExecutables = contentAllow
+
+	// each link points inside of the module as text, e resolves to its parent
+	dir := createDangerousModule(t, false)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "d"), os.ModePerm))
+	assert.NoError(t, os.Symlink("..", filepath.Join(dir, "d/l")))
+	assert.NoError(t, os.Symlink("d/l/..", filepath.Join(dir, "e")))
+	report, err := scanModule(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.rejected())
+	assert.Equal(t, []string{"e"}, findingPaths(report))
+
+	// dangling links are resolved as far as they exist
+	assert.NoError(t, os.Remove(filepath.Join(dir, "e")))
+	assert.NoError(t, os.Symlink("d/l/../missing", filepath.Join(dir, "e")))
+	assert.NoError(t, os.Symlink("d/l/missing", filepath.Join(dir, "f")))
+	report, err = scanModule(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e"}, findingPaths(report))
+}
+
+func TestScanModuleExecutablesAndLimits(t *testing.T) {
+	defer restoreOpts()()
+	opts.ContentAction = contentReject
+	opts.ContentExecutables = contentStrip
+
+	dir := createDangerousModule(t, false)
+	report, err := scanModule(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.rejected())
+	assert.Equal(t, []string{"scripts/run.sh"}, findingPaths(report))
+	info, err := os.Stat(filepath.Join(dir, "scripts/run.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	opts.ContentExecutables = contentAllow
+	opts.ContentMaxFiles = 2
+	report, err = scanModule(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.rejected())
+
+	opts.ContentMaxFiles = 0
+	opts.ContentMaxFileSize = 5
+	report, err = scanModule(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.rejected())
+	assert.Contains(t, findingPaths(report), "main.tf")
+}
+
+// createDangerousModule creates a fetched module and, if dangerous is set,
+// adds a symlink to credentials outside of the module and a nested repository
+func createDangerousModule(t *testing.T, dangerous bool) string {
+	dir := filepath.Join(t.TempDir(), "module")
+	for _, folder := range []string{".git", "scripts", "examples"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, folder), os.ModePerm))
+	}
+	createFile(t, filepath.Join(dir, ".git/HEAD"), "ref: refs/heads/master\n")
+	createFile(t, filepath.Join(dir, "main.tf"), "# module with some content\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "scripts/run.sh"), []byte("#!/bin/sh\n"), 0755))
+	assert.NoError(t, os.Symlink("../main.tf", filepath.Join(dir, "examples/main.tf")))
+
+	if dangerous {
+		assert.NoError(t, os.Symlink(strings.Repeat("../", 3)+".aws/credentials", filepath.Join(dir, "credentials")))
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "examples/.git"), os.ModePerm))
+		createFile(t, filepath.Join(dir, "examples/.git/config"), "[core]\n")
+	}
+
+	return dir
+}
+
+func findingPaths(report contentReport) []string {
+	var paths []string
+	for _, finding := range report.Findings {
+		paths = append(paths, finding.Path)
+	}
+	return paths
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expandDestinations replaces glob patterns in destinations of modules of
+// config with the existing folders they match. Patterns starting with `!`
+// remove matching folders added by the patterns before them.
+func expandDestinations(config map[string]module) []error {
+	var errs []error
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		if !hasPatterns(m.Destinations) {
+			continue
+		}
+
+		destinations, notes, err := expandPatterns(key, m.Destinations)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.Destinations = destinations
+		m.DestinationNotes = notes
+		config[key] = m
+	}
+
+	return errs
+}
+
+// expandPatterns expands destinations of module key, it returns the expanded
+// destinations in order and notes on the pattern each of them came from
+func expandPatterns(key string, destinations []string) ([]string, map[string]string, error) {
+	var expanded []string
+	notes := make(map[string]string)
+	seen := make(map[string]bool)
+
+	for _, destination := range destinations {
+		if pattern := strings.TrimPrefix(destination, "!"); pattern != destination {
+			pattern = path.Clean(filepath.ToSlash(pattern))
+			var kept []string
+			for _, d := range expanded {
+				if matchGlob(pattern, path.Clean(filepath.ToSlash(d))) {
+					delete(seen, d)
+					delete(notes, d)
+					continue
+				}
+				kept = append(kept, d)
+			}
+			if len(kept) == len(expanded) {
+				log.Warnf("[*] Destination pattern %q of module %s excludes nothing", destination, key)
+			}
+			expanded = kept
+			continue
+		}
+
+		if !isPattern(destination) {
+			if !seen[destination] {
+				seen[destination] = true
+				expanded = append(expanded, destination)
+			}
+			continue
+		}
+
+		matches, err := globFolders(destination)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to expand destination pattern %q of module %s due to error: %s", destination, key, err)
+		}
+		if len(matches) == 0 {
+			log.Warnf("[*] Destination pattern %q of module %s matches no folder", destination, key)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				notes[match] = "from " + destination
+				expanded = append(expanded, match)
+			}
+		}
+	}
+
+	// a module without destinations is installed into the root, which is
+	// never what patterns matching nothing mean
+	if len(expanded) == 0 {
+		return nil, nil, fmt.Errorf("destinations of module %s match no folder", key)
+	}
+
+	return expanded, notes, nil
+}
+
+// globFolders returns existing folders matching pattern, in lexical order.
+// Hidden folders, module paths and folders leading to them are never matched.
+// As ** matches no folder as well, stacks/** matches stacks itself.
+func globFolders(pattern string) ([]string, error) {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+
+	// walk from the longest part of the pattern without wildcards
+	segments := strings.Split(pattern, "/")
+	base := "."
+	for len(segments) > 1 && !isPattern(segments[0]) {
+		base = path.Join(base, segments[0])
+		segments = segments[1:]
+	}
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	modulePath := path.Clean(filepath.ToSlash(opts.ModulePath))
+	var matches []string
+	err := filepath.WalkDir(filepath.FromSlash(base), func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		rel := filepath.ToSlash(p)
+		if rel != base && (strings.HasPrefix(entry.Name(), ".") || rel == modulePath || strings.HasSuffix(rel, "/"+modulePath)) {
+			return filepath.SkipDir
+		}
+		if matchGlob(pattern, rel) && !leadsToModulePath(rel, modulePath) {
+			matches = append(matches, filepath.FromSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(matches)
+
+	return matches, err
+}
+
+// leadsToModulePath tells whether folder dir is part of a module path below
+// it, like stacks/app/vendor is of stacks/app/vendor/modules
+func leadsToModulePath(dir string, modulePath string) bool {
+	segments := strings.Split(modulePath, "/")
+	for i := 1; i < len(segments); i++ {
+		parent := strings.Join(segments[:i], "/")
+		if dir != parent && !strings.HasSuffix(dir, "/"+parent) {
+			continue
+		}
+		if info, err := os.Stat(filepath.FromSlash(path.Join(dir, strings.Join(segments[i:], "/")))); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// isPattern reports whether destination is a glob pattern or an exclusion
+func isPattern(destination string) bool {
+	return strings.HasPrefix(destination, "!") || strings.ContainsAny(destination, "*?[")
+}
+
+func hasPatterns(destinations []string) bool {
+	for _, d := range destinations {
+		if isPattern(d) {
+			return true
+		}
+	}
+	return false
+}
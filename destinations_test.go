@@ -0,0 +1,89 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandDestinations(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{
+		"stacks/networking/prod",
+		"stacks/networking/dev",
+		"stacks/onboarding/prod",
+		"stacks/legacy/prod",
+		"stacks/.terraform",
+		"stacks/onboarding/vendor/modules/tf-aws-vpc",
+	} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+
+	config := map[string]module{
+		"all":   {Destinations: []string{"shared", "stacks/*", "!stacks/legacy"}},
+		"prod":  {Destinations: []string{"stacks/**/prod"}},
+		"plain": {Destinations: []string{"networking"}},
+	}
+	assert.Empty(t, expandDestinations(config))
+
+	assert.Equal(t, []string{"shared", "stacks/networking", "stacks/onboarding"}, config["all"].Destinations)
+	assert.Equal(t, map[string]string{"stacks/networking": "from stacks/*", "stacks/onboarding": "from stacks/*"}, config["all"].DestinationNotes)
+	assert.Equal(t, []string{"stacks/legacy/prod", "stacks/networking/prod", "stacks/onboarding/prod"}, config["prod"].Destinations)
+	assert.Equal(t, []string{"networking"}, config["plain"].Destinations)
+	assert.Nil(t, config["plain"].DestinationNotes)
+
+	// matching nothing at all is an error rather than installing into the root
+	errs := expandDestinations(map[string]module{"none": {Destinations: []string{"stacks/*/qa"}}})
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], "destinations of module none match no folder")
+	}
+}
+
+func TestExpandDestinationsInstalled(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{"stacks/app", "stacks/db"} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+	m := module{Source: createGitRepository(t, "v1.0.0"), Version: "v1.0.0", Destinations: []string{"stacks/**", "!stacks"}}
+
+	// installing into the destinations leaves them as they are
+	var runs [][]string
+	for i := 0; i < 2; i++ {
+		config := map[string]module{"vpc": m}
+		assert.Empty(t, expandDestinations(config))
+		runs = append(runs, config["vpc"].Destinations)
+		installModules(config, workDir, nil)
+	}
+	assert.Equal(t, []string{"stacks/app", "stacks/db"}, runs[0])
+	assert.Equal(t, runs[0], runs[1])
+	assert.DirExists(t, filepath.Join("stacks/app", opts.ModulePath, "vpc"))
+	assert.NoDirExists(t, filepath.Join("stacks/app/vendor", opts.ModulePath))
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// retryDelay is the time waited before the first retry, doubled on every next one
+var retryDelay = 2 * time.Second
+
+// installModuleWithRetries installs module m, retrying up to --retries times
+// after a failure
+func installModuleWithRetries(key string, m module, destinationDir string) error {
+	err := installModule(key, m, destinationDir)
+	delay := retryDelay
+	for attempt := 1; err != nil && attempt <= opts.Retries; attempt++ {
+		log.Warnf("[*] Failed to install module %s due to error: %s, retrying in %s (%d/%d)", key, err, delay, attempt, opts.Retries)
+		time.Sleep(delay)
+		delay *= 2
+		err = installModule(key, m, destinationDir)
+	}
+	return err
+}
+
+// installModule fetches module m into destinationDir/key, unless the module
+// installed there already is an unmodified copy of the same commit. The module
+// is fetched into a staging folder first, so that a failed fetch or rejected
+// content never replaces an installed module.
+func installModule(key string, m module, destinationDir string) error {
+	return fetchModule(key, m, destinationDir, nil)
+}
+
+// fetchModule installs module m like installModule, nested is set for
+// modules of Terrafiles shipped by fetched modules
+func fetchModule(key string, m module, destinationDir string, nested *nestedContext) error {
+	moduleDir := filepath.Join(destinationDir, key)
+	// sources are pointed to vendored modules of the top-level module only
+	transitive := opts.Transitive && nested == nil
+
+	if commit, ok := upToDate(moduleDir, m, transitive); ok {
+		log.Infof("[*] %s is up to date at %s of %s (%s)", moduleDir, m.Version, m.Source, commit)
+		return nil
+	}
+
+	staging, err := os.MkdirTemp(destinationDir, ".terrafile-"+key+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	staged := filepath.Join(staging, key)
+	if checkout, ok := nested.checkedOut(m.Source, m.Version); ok {
+		err = copyTree(checkout, staged)
+	} else {
+		err = gitClone(m.Source, m.Version, key, staging)
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := gitOutput(staged, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	commit := strings.TrimSpace(string(out))
+
+	// leave out files the module doesn't need
+	removed, err := filterModule(staged, newContentFilter(m.Include, m.Exclude))
+	if err != nil {
+		return fmt.Errorf("failed to filter content due to error: %s", err)
+	}
+	if removed > 0 {
+		log.Infof("[*] Excluded %d file(s) of %s", removed, key)
+	}
+
+	// check content before it's placed into stacks
+	report, err := scanModule(staged)
+	if err != nil {
+		return fmt.Errorf("failed to check content due to error: %s", err)
+	}
+	report.log(key)
+	if report.rejected() {
+		return fmt.Errorf("module was rejected due to its content")
+	}
+
+	if !opts.KeepGit {
+		if err := os.RemoveAll(filepath.Join(staged, ".git")); err != nil {
+			return err
+		}
+	}
+
+	if opts.Nested {
+		if err := installNested(staged, key, m, nested); err != nil {
+			return err
+		}
+	}
+
+	// point remote modules it uses, including those of modules installed for
+	// its Terrafile, to copies vendored next to it
+	var dependencies []moduleDependency
+	if transitive {
+		if dependencies, err = vendorSources(staged, m); err != nil {
+			return fmt.Errorf("failed to point module sources to vendored modules due to error: %s", err)
+		}
+	}
+
+	files, hash, err := hashModule(staged)
+	if err != nil {
+		return err
+	}
+	metadata := moduleMetadata{Source: m.Source, Version: m.Version, Commit: commit, Filters: moduleFilters(m), Hash: hash, Files: files,
+		Nested: opts.Nested, Transitive: transitive, Dependencies: dependencies}
+	if err := writeMetadata(staged, metadata); err != nil {
+		return err
+	}
+
+	log.Printf("[*] Removing previously cloned artifacts at %s", moduleDir)
+	if err := os.RemoveAll(moduleDir); err != nil {
+		return err
+	}
+
+	return os.Rename(staged, moduleDir)
+}
+
+// upToDate tells whether module installed in moduleDir is an unmodified copy
+// of the commit version of m currently points to, and returns the commit.
+// transitive tells whether its sources should point to vendored modules.
+func upToDate(moduleDir string, m module, transitive bool) (string, bool) {
+	metadata, err := readMetadata(moduleDir)
+	if err != nil || metadata == nil || metadata.Source != m.Source || metadata.Version != m.Version {
+		return "", false
+	}
+	if strings.Join(metadata.Filters, "\n") != strings.Join(moduleFilters(m), "\n") {
+		return "", false
+	}
+	if metadata.Nested != opts.Nested || metadata.Transitive != transitive {
+		return "", false
+	}
+
+	// installed in another mode
+	if _, err := os.Stat(filepath.Join(moduleDir, ".git")); (err == nil) != opts.KeepGit {
+		return "", false
+	}
+
+	if _, hash, err := hashModule(moduleDir); err != nil || hash != metadata.Hash {
+		return "", false
+	}
+
+	commit, err := remoteCommit(m.Source, m.Version)
+	if err != nil || commit != metadata.Commit {
+		return "", false
+	}
+
+	return commit, true
+}
+
+// moduleFilters returns include and exclude patterns of m as recorded in metadata
+func moduleFilters(m module) []string {
+	var filters []string
+	for _, pattern := range m.Include {
+		filters = append(filters, "include:"+pattern)
+	}
+	for _, pattern := range m.Exclude {
+		filters = append(filters, "exclude:"+pattern)
+	}
+	return filters
+}
+
+// pruneModulePath removes everything from module path except for modules the
+// config installs there
+func pruneModulePath(config map[string]module) {
+	keep := make(map[string]bool)
+	for key, m := range config {
+		if cloneDestination, _ := moduleDestinations(m); filepath.Clean(cloneDestination) == filepath.Clean(opts.ModulePath) {
+			keep[key] = true
+		}
+	}
+	if opts.Transitive {
+		keepDependencies(keep)
+	}
+
+	entries, err := os.ReadDir(opts.ModulePath)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if keep[entry.Name()] {
+			continue
+		}
+
+		path := filepath.Join(opts.ModulePath, entry.Name())
+		log.Infof("[*] Removing %s as it's not in the Terrafile", path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Errorf("failed to remove %s due to error: %s", path, err)
+		}
+	}
+}
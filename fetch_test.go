@@ -0,0 +1,192 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallModule(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+
+	m := module{Source: createGitRepository(t, "v1.0.0"), Version: "v1.0.0"}
+	destination := t.TempDir()
+	moduleDir := filepath.Join(destination, "tf-aws-vpc")
+
+	assert.NoError(t, installModule("tf-aws-vpc", m, destination))
+	assert.FileExists(t, filepath.Join(moduleDir, "main.tf"))
+	assert.NoDirExists(t, filepath.Join(moduleDir, ".git"))
+
+	metadata, err := readMetadata(moduleDir)
+	assert.NoError(t, err)
+	if assert.NotNil(t, metadata) {
+		commit, err := remoteCommit(m.Source, m.Version)
+		assert.NoError(t, err)
+		assert.Equal(t, commit, metadata.Commit)
+		assert.Equal(t, []string{"main.tf"}, mapKeys(metadata.Files))
+	}
+
+	// unmodified module of the same commit is kept
+	commit, ok := upToDate(moduleDir, m, false)
+	assert.True(t, ok)
+	assert.Equal(t, metadata.Commit, commit)
+
+	createFile(t, filepath.Join(moduleDir, "main.tf"), "# hotfix\n")
+	changes, err := localModifications(moduleDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{" M main.tf"}, changes)
+	_, ok = upToDate(moduleDir, m, false)
+	assert.False(t, ok)
+
+	// reinstalling restores the module
+	assert.NoError(t, installModule("tf-aws-vpc", m, destination))
+	changes, err = localModifications(moduleDir)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// no leftovers of staging
+	entries, err := os.ReadDir(destination)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	opts.KeepGit = true
+	_, ok = upToDate(moduleDir, m, false)
+	assert.False(t, ok)
+	assert.NoError(t, installModule("tf-aws-vpc", m, destination))
+	assert.DirExists(t, filepath.Join(moduleDir, ".git"))
+}
+
+func TestStashExportedModule(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.StashDir = t.TempDir()
+
+	m := module{Source: createGitRepository(t, "v1.0.0"), Version: "v1.0.0"}
+	destination := t.TempDir()
+	moduleDir := filepath.Join(destination, "tf-aws-vpc")
+	assert.NoError(t, installModule("tf-aws-vpc", m, destination))
+
+	createFile(t, filepath.Join(moduleDir, "main.tf"), "# hotfix\n")
+	createFile(t, filepath.Join(moduleDir, "extra.tf"), "# new file\n")
+
+	patch, err := stashModule(moduleDir)
+	assert.NoError(t, err)
+	contents, err := os.ReadFile(patch)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "+# hotfix")
+	assert.Contains(t, string(contents), "+# new file")
+	assert.NotContains(t, string(contents), metadataFile)
+
+	// stashing again never overwrites an earlier patch
+	again, err := stashModule(moduleDir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, patch, again)
+	stashed, err := os.ReadFile(patch)
+	assert.NoError(t, err)
+	assert.Equal(t, contents, stashed)
+}
+
+func TestStashFilteredModule(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.StashDir = t.TempDir()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	source := createGitRepository(t)
+	assert.NoError(t, os.MkdirAll(filepath.Join(source[len("file://"):], "examples/a"), os.ModePerm))
+	commitNestedRepository(t, source, "v1.0.0", map[string]string{"examples/a/main.tf": "# example\n"})
+	m := module{Source: source, Version: "v1.0.0", Exclude: []string{"examples"}}
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	assert.NoError(t, installModule("tf-aws-vpc", m, opts.ModulePath))
+	moduleDir := filepath.Join(opts.ModulePath, "tf-aws-vpc")
+
+	createFile(t, filepath.Join(moduleDir, "main.tf"), "# hotfix\n")
+	createFile(t, filepath.Join(moduleDir, "extra.tf"), "# new file\n")
+	patch, err := stashModule(moduleDir)
+	assert.NoError(t, err)
+	contents, err := os.ReadFile(patch)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(contents), "examples")
+	assert.NotContains(t, string(contents), "deleted file")
+
+	// the patch applies to a fresh install of the module
+	assert.NoError(t, os.RemoveAll(moduleDir))
+	assert.NoError(t, installModule("tf-aws-vpc", m, opts.ModulePath))
+	_, err = gitOutput(".", "apply", "--directory="+filepath.ToSlash(moduleDir), patch)
+	assert.NoError(t, err)
+	changes, err := localModifications(moduleDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"?? extra.tf", " M main.tf"}, changes)
+	data, err := os.ReadFile(filepath.Join(moduleDir, "main.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "# hotfix\n", string(data))
+}
+
+func TestPruneModulePath(t *testing.T) {
+	defer restoreOpts()()
+	opts.ModulePath = t.TempDir()
+
+	for _, name := range []string{"tf-aws-vpc", "tf-aws-iam", ".terrafile-tf-aws-vpc-123"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(opts.ModulePath, name), os.ModePerm))
+	}
+
+	pruneModulePath(map[string]module{
+		"tf-aws-vpc": {},
+		"tf-aws-iam": {Destinations: []string{"iam"}},
+	})
+
+	entries, err := os.ReadDir(opts.ModulePath)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "tf-aws-vpc", entries[0].Name())
+	}
+}
+
+// setTestOpts sets options the way default flags do, allowing local repositories
+func setTestOpts() {
+	opts.ModulePath = "vendor/modules"
+	opts.GitProtocols = []string{"file"}
+	opts.ContentAction = contentReject
+	opts.ContentExecutables = contentAllow
+}
+
+// createGitRepository creates a repository with a committed main.tf, tagged with tags
+func createGitRepository(t *testing.T, tags ...string) string {
+	dir := t.TempDir()
+	createGitModule(t, dir)
+	for _, tag := range tags {
+		_, err := gitOutput(dir, "tag", tag)
+		assert.NoError(t, err)
+	}
+	return "file://" + filepath.ToSlash(dir)
+}
+
+func mapKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ignoreFile is the name of the file next to the Terrafile listing patterns
+// of files to exclude from every module
+const ignoreFile = ".terrafileignore"
+
+// globRule is a single include or exclude pattern using .gitignore syntax
+type globRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func newGlobRule(pattern string) globRule {
+	var rule globRule
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	// patterns with a slash anywhere but at the end are relative to module root
+	if strings.Contains(pattern, "/") {
+		rule.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	rule.pattern = pattern
+
+	return rule
+}
+
+// matches tells whether rule matches slash separated path relative to module root
+func (r globRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return matchGlob(r.pattern, path.Base(rel))
+	}
+	return matchGlob(r.pattern, rel)
+}
+
+// contentFilter decides which files of a fetched module are installed
+type contentFilter struct {
+	include []globRule
+	exclude []globRule
+}
+
+func newContentFilter(include []string, exclude []string) contentFilter {
+	var f contentFilter
+	for _, pattern := range include {
+		f.include = append(f.include, newGlobRule(pattern))
+	}
+	for _, pattern := range exclude {
+		f.exclude = append(f.exclude, newGlobRule(pattern))
+	}
+	return f
+}
+
+// excluded tells whether file at slash separated path relative to module root
+// is left out. A rule matching any of the parent folders applies to the file.
+func (f contentFilter) excluded(rel string) bool {
+	candidates := parents(rel)
+
+	if len(f.include) > 0 && !matchAny(f.include, candidates, rel) {
+		return true
+	}
+
+	excluded := false
+	for _, rule := range f.exclude {
+		if matchAny([]globRule{rule}, candidates, rel) {
+			excluded = !rule.negate
+		}
+	}
+
+	return excluded
+}
+
+// matchAny tells whether any rule matches file rel or any of its parent candidates
+func matchAny(rules []globRule, candidates []string, rel string) bool {
+	for _, rule := range rules {
+		for _, candidate := range candidates {
+			if rule.matches(candidate, candidate != rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parents returns every parent folder of slash separated path and path itself
+func parents(rel string) []string {
+	var candidates []string
+	for i, c := range rel {
+		if c == '/' {
+			candidates = append(candidates, rel[:i])
+		}
+	}
+	return append(candidates, rel)
+}
+
+// matchGlob matches slash separated name against pattern, where `**` matches
+// any number of folders and other wildcards follow path.Match
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// validGlob tells whether pattern has valid syntax
+func validGlob(pattern string) bool {
+	rule := newGlobRule(pattern)
+	if rule.pattern == "" {
+		return false
+	}
+	for _, segment := range strings.Split(rule.pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// filterModule removes files the filter excludes from module in dir, along
+// with folders left empty. Git and terrafile metadata are never removed.
+func filterModule(dir string, f contentFilter) (removed int, err error) {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return 0, nil
+	}
+
+	var folders []string
+	err = filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case rel == ".git" && entry.IsDir():
+			return filepath.SkipDir
+		case rel == "." || rel == metadataFile:
+			return nil
+		case entry.IsDir():
+			folders = append(folders, p)
+			return nil
+		}
+
+		if f.excluded(rel) {
+			removed++
+			return os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	// deepest folders first, so that parents are empty by the time they're checked
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	for _, folder := range folders {
+		if entries, err := os.ReadDir(folder); err == nil && len(entries) == 0 {
+			_ = os.Remove(folder)
+		}
+	}
+
+	return removed, nil
+}
+
+// readIgnoreFile returns patterns listed in .terrafileignore next to the
+// Terrafile, skipping blank lines and comments
+func readIgnoreFile(terrafilePath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(terrafilePath), ignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns, scanner.Err()
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentFilter(t *testing.T) {
+	f := newContentFilter(nil, []string{"examples/", "/test", ".github", "*.md", "!README.md", "docs/**/*.png"})
+	for rel, excluded := range map[string]bool{
+		"main.tf":                         false,
+		"README.md":                       false,
+		"CHANGELOG.md":                    true,
+		"modules/vpc/README.md":           false,
+		"modules/vpc/usage.md":            true,
+		"examples/complete/main.tf":       true,
+		"modules/vpc/examples/main.tf":    true,
+		"examples.tf":                     false,
+		"test/vpc_test.go":                true,
+		"modules/test/main.tf":            false,
+		".github/workflows/ci.yml":        true,
+		"docs/diagram.png":                true,
+		"docs/images/nested/vpc.png":      true,
+		"modules/docs/images/diagram.png": false,
+	} {
+		assert.Equal(t, excluded, f.excluded(rel), rel)
+	}
+
+	f = newContentFilter([]string{"*.tf", "modules/**"}, []string{"modules/legacy"})
+	for rel, excluded := range map[string]bool{
+		"main.tf":                   false,
+		"README.md":                 true,
+		"examples/complete/main.tf": false,
+		"modules/vpc/README.md":     false,
+		"modules/legacy/main.tf":    true,
+	} {
+		assert.Equal(t, excluded, f.excluded(rel), rel)
+	}
+}
+
+func TestFilterModule(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"main.tf", "README.md", "examples/complete/main.tf", ".git/HEAD", metadataFile} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), os.ModePerm))
+		createFile(t, filepath.Join(dir, file), file)
+	}
+
+	removed, err := filterModule(dir, newContentFilter(nil, []string{"examples/", "*.md", "HEAD"}))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.FileExists(t, filepath.Join(dir, "main.tf"))
+	assert.FileExists(t, filepath.Join(dir, ".git/HEAD"))
+	assert.FileExists(t, filepath.Join(dir, metadataFile))
+	assert.NoFileExists(t, filepath.Join(dir, "README.md"))
+	assert.NoDirExists(t, filepath.Join(dir, "examples"))
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	terrafile := filepath.Join(dir, "Terrafile")
+
+	patterns, err := readIgnoreFile(terrafile)
+	assert.NoError(t, err)
+	assert.Empty(t, patterns)
+
+	createFile(t, filepath.Join(dir, ignoreFile), "# upstream noise\nexamples/\n\n  test/  \n")
+	patterns, err = readIgnoreFile(terrafile)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"examples/", "test/"}, patterns)
+}
+
+func TestValidGlob(t *testing.T) {
+	assert.True(t, validGlob("examples/**/*.tf"))
+	assert.True(t, validGlob("!README.md"))
+	assert.False(t, validGlob("[examples"))
+	assert.False(t, validGlob("/"))
+}
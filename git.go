@@ -0,0 +1,201 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// gitCommand returns git command running args in dir. Unless disabled, git
+// runs isolated from system and global configuration, without hooks and
+// restricted to allowed protocols.
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	var sandbox []string
+	env := os.Environ()
+
+	if !opts.GitNoSandbox {
+		sandbox = []string{
+			"-c", "core.hooksPath=" + os.DevNull,
+			"-c", "core.fsmonitor=false",
+			"-c", "protocol.ext.allow=never",
+			"-c", "protocol.allow=never",
+		}
+		for _, protocol := range opts.GitProtocols {
+			sandbox = append(sandbox, "-c", fmt.Sprintf("protocol.%s.allow=always", protocol))
+		}
+
+		env = append(env,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_TERMINAL_PROMPT=0",
+			"GIT_ALLOW_PROTOCOL="+strings.Join(opts.GitProtocols, ":"),
+		)
+		if !opts.GitGlobalConfig {
+			env = append(env, "GIT_CONFIG_GLOBAL="+os.DevNull)
+		}
+	}
+
+	for _, config := range opts.GitConfig {
+		sandbox = append(sandbox, "-c", config)
+	}
+
+	cmd := exec.Command("git", append(sandbox, args...)...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	return cmd
+}
+
+// gitClone clones version of repository, or its mirror, into destinationDir/moduleName
+func gitClone(repository string, version string, moduleName string, destinationDir string) error {
+	log.Printf("[*] Checking out %s of %s \n", version, repository)
+	return fetchMirrored(repository, func(location string) error {
+		if err := checkSource(location, version); err != nil {
+			return fmt.Errorf("refusing to clone repository %s due to error: %s", location, err)
+		}
+
+		_, err := gitOutput(destinationDir, "clone", "--single-branch", "--depth=1", "--branch="+version, "--", location, moduleName)
+		return err
+	})
+}
+
+// remoteCommit returns the commit version of repository, or its mirror, points
+// to, without fetching it
+func remoteCommit(repository string, version string) (string, error) {
+	var commit string
+	err := fetchMirrored(repository, func(location string) error {
+		var err error
+		commit, err = lsRemote(location, version)
+		return err
+	})
+	return commit, err
+}
+
+func lsRemote(repository string, version string) (string, error) {
+	if err := checkSource(repository, version); err != nil {
+		return "", err
+	}
+
+	out, err := gitOutput("", "ls-remote", "--", repository, "refs/heads/"+version, "refs/tags/"+version)
+	if err != nil {
+		return "", err
+	}
+
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+
+	// same order of preference as `git clone --branch`, tags are peeled to their commits
+	for _, ref := range []string{"refs/heads/" + version, "refs/tags/" + version + "^{}", "refs/tags/" + version} {
+		if commit, ok := refs[ref]; ok {
+			return commit, nil
+		}
+	}
+
+	return "", fmt.Errorf("version %s not found in repository %s", version, repository)
+}
+
+// gitOutput runs git in dir and returns its standard output
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := gitCommand(dir, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", cmd.String(), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// checkSources makes sure no module source or version can be mistaken for a
+// git option and every source, and mirror it is fetched from, uses an allowed
+// protocol
+func checkSources(config map[string]module) []error {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		for _, location := range mirrorSources(config[key].Source) {
+			if err := checkSource(location, config[key].Version); err != nil {
+				errs = append(errs, fmt.Errorf("module %q: %s", key, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+func checkSource(source string, version string) error {
+	if strings.HasPrefix(source, "-") {
+		return fmt.Errorf("source %q must not start with '-'", source)
+	}
+	if strings.HasPrefix(version, "-") {
+		return fmt.Errorf("version %q must not start with '-'", version)
+	}
+
+	if opts.GitNoSandbox {
+		return nil
+	}
+
+	protocol := sourceProtocol(source)
+	for _, allowed := range opts.GitProtocols {
+		if protocol == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("source %q uses protocol %q which is not allowed, allowed protocols are: %s", source, protocol, strings.Join(opts.GitProtocols, ", "))
+}
+
+// sourceProtocol returns the protocol git would use to fetch source
+func sourceProtocol(source string) string {
+	// <transport>::<address> is handled by remote helper git-remote-<transport>
+	if i := strings.Index(source, "::"); i > 0 && !strings.ContainsAny(source[:i], "/:") {
+		return strings.ToLower(source[:i])
+	}
+
+	if i := strings.Index(source, "://"); i > 0 {
+		scheme := strings.ToLower(source[:i])
+		switch scheme {
+		case "git+ssh", "ssh+git":
+			return "ssh"
+		}
+		return scheme
+	}
+
+	// scp-like syntax, [user@]host:path, as long as there's no slash before the colon
+	if i := strings.Index(source, ":"); i > 0 && !strings.Contains(source[:i], "/") {
+		return "ssh"
+	}
+
+	return "file"
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceProtocol(t *testing.T) {
+	for source, protocol := range map[string]string{
+		"git@github.com:terraform-aws-modules/terraform-aws-vpc":           "ssh",
+		"ssh://git@github.com/terraform-aws-modules/terraform-aws-vpc":     "ssh",
+		"git+ssh://git@github.com/terraform-aws-modules/terraform-aws-vpc": "ssh",
+		"https://github.com/terraform-aws-modules/terraform-aws-vpc":       "https",
+		"HTTP://github.com/terraform-aws-modules/terraform-aws-vpc":        "http",
+		"ext::sh -c touch% /tmp/pwned":                                     "ext",
+		"fd::17":                                                           "fd",
+		"file:///srv/git/terraform-aws-vpc":                                "file",
+		"../terraform-aws-vpc":                                             "file",
+		"./modules/with:colon":                                             "file",
+	} {
+		assert.Equal(t, protocol, sourceProtocol(source), source)
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	defer restoreOpts()()
+	opts.GitProtocols = []string{"https", "ssh", "file"}
+
+	assert.NoError(t, checkSource("git@github.com:terraform-aws-modules/terraform-aws-vpc", "v1.46.0"))
+	assert.Error(t, checkSource("--upload-pack=touch /tmp/pwned", "master"))
+	assert.Error(t, checkSource("git@github.com:terraform-aws-modules/terraform-aws-vpc", "--config=core.sshCommand=touch"))
+	assert.Error(t, checkSource("ext::sh -c touch% /tmp/pwned", "master"))
+	assert.Error(t, checkSource("http://github.com/terraform-aws-modules/terraform-aws-vpc", "master"))
+
+	opts.GitProtocols = append(opts.GitProtocols, "http")
+	assert.NoError(t, checkSource("http://github.com/terraform-aws-modules/terraform-aws-vpc", "master"))
+}
+
+func TestGitCommandSandbox(t *testing.T) {
+	defer restoreOpts()()
+	opts.GitProtocols = []string{"file"}
+
+	repository := t.TempDir()
+	createGitModule(t, repository)
+	destination := t.TempDir()
+
+	// allowed protocol
+	_, err := gitOutput(destination, "clone", "--", "file://"+repository, "allowed")
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(destination, "allowed", "main.tf"))
+
+	// ext transport runs arbitrary commands
+	_, err = gitOutput(destination, "clone", "--", "ext::sh -c touch% "+filepath.Join(destination, "pwned"), "ext")
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(destination, "pwned"))
+
+	opts.GitProtocols = []string{"https"}
+	_, err = gitOutput(destination, "clone", "--", "file://"+repository, "disallowed")
+	assert.Error(t, err)
+}
@@ -5,15 +5,14 @@ go 1.18
 require (
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/nritholtz/stdemuxerhook v0.0.0-20181016194454-2c86ca05d211
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/rendon/testcli v0.0.0-20161027181003-6283090d169f
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.8.1
-	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20220608164250-635b8c9b7f68 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
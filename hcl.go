@@ -0,0 +1,255 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// moduleBlock is a `module` block of a terraform file
+type moduleBlock struct {
+	Name    string
+	Source  string
+	Version string
+	Line    int
+
+	// SourceSpan is the offset of the quoted source string, VersionSpan the
+	// offset of the whole version attribute, if there is one
+	SourceSpan  [2]int
+	VersionSpan [2]int
+}
+
+// hclToken is a token of a terraform file, strings are unquoted
+type hclToken struct {
+	kind  byte // 'i' identifier, 's' string, or the punctuation character itself
+	value string
+	line  int
+	// start and end offset of the token
+	start int
+	end   int
+}
+
+// scanModuleBlocks returns top-level `module` blocks of terraform file
+// contents with their literal `source`. It understands just enough of HCL to
+// skip comments, strings and heredocs, anything else is ignored.
+func scanModuleBlocks(data string) []moduleBlock {
+	tokens := tokenizeHCL(data)
+
+	var blocks []moduleBlock
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].kind {
+		case '{':
+			depth++
+			continue
+		case '}':
+			depth--
+			continue
+		}
+
+		// module "name" {
+		if depth != 0 || tokens[i].kind != 'i' || tokens[i].value != "module" || i+2 >= len(tokens) ||
+			tokens[i+1].kind != 's' || tokens[i+2].kind != '{' {
+			continue
+		}
+		block := moduleBlock{Name: tokens[i+1].value, Line: tokens[i].line}
+
+		// source = "..." directly in the block body
+		i += 3
+		for level := 1; i < len(tokens) && level > 0; i++ {
+			switch tokens[i].kind {
+			case '{':
+				level++
+			case '}':
+				level--
+			case 'i':
+				if level != 1 || i+2 >= len(tokens) || tokens[i+1].kind != '=' || tokens[i+2].kind != 's' {
+					continue
+				}
+				switch tokens[i].value {
+				case "source":
+					block.Source = tokens[i+2].value
+					block.SourceSpan = [2]int{tokens[i+2].start, tokens[i+2].end}
+				case "version":
+					block.Version = tokens[i+2].value
+					block.VersionSpan = [2]int{tokens[i].start, tokens[i+2].end}
+				}
+			}
+		}
+		i--
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// tokenizeHCL splits terraform file contents into tokens
+func tokenizeHCL(data string) []hclToken {
+	var tokens []hclToken
+	line := 1
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(data[i:], "//"):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(data[i:], "/*"):
+			end := strings.Index(data[i+2:], "*/")
+			if end < 0 {
+				end = len(data) - i - 2
+			}
+			line += strings.Count(data[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			value, n := scanHCLString(data[i:])
+			tokens = append(tokens, hclToken{kind: 's', value: value, line: line, start: i, end: i + n})
+			line += strings.Count(data[i:i+n], "\n")
+			i += n
+		case strings.HasPrefix(data[i:], "<<"):
+			n := skipHeredoc(data[i:])
+			tokens = append(tokens, hclToken{kind: 'h', line: line, start: i, end: i + n})
+			line += strings.Count(data[i:i+n], "\n")
+			i += n
+		case isIdentifierChar(c):
+			start := i
+			for i < len(data) && isIdentifierChar(data[i]) {
+				i++
+			}
+			tokens = append(tokens, hclToken{kind: 'i', value: data[start:i], line: line, start: start, end: i})
+		default:
+			tokens = append(tokens, hclToken{kind: c, line: line, start: i, end: i + 1})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// scanHCLString returns the unquoted value of the string data starts with and
+// its length including quotes. Interpolations are kept as they are.
+func scanHCLString(data string) (string, int) {
+	var value strings.Builder
+	nesting := 0
+	for i := 1; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch data[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(data[i])
+			}
+			continue
+		case strings.HasPrefix(data[i:], "$${") || strings.HasPrefix(data[i:], "%%{"):
+			// escaped template sequence
+			value.WriteString(data[i+1 : i+3])
+			i += 2
+			continue
+		case strings.HasPrefix(data[i:], "${"):
+			nesting++
+		case c == '}' && nesting > 0:
+			nesting--
+		case c == '"' && nesting == 0:
+			return value.String(), i + 1
+		case c == '\n' && nesting == 0:
+			// unterminated string
+			return value.String(), i
+		}
+		value.WriteByte(c)
+	}
+	return value.String(), len(data)
+}
+
+// quoteHCLString returns s as a quoted HCL string literal. Template sequences
+// are escaped, so that s is taken literally rather than as an expression.
+func quoteHCLString(s string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteRune(r)
+		case r == '\n':
+			quoted.WriteString(`\n`)
+		case r == '\r':
+			quoted.WriteString(`\r`)
+		case r == '\t':
+			quoted.WriteString(`\t`)
+		case (r == '$' || r == '%') && strings.HasPrefix(s[i+1:], "{"):
+			// $${ and %%{ are literal ${ and %{
+			quoted.WriteRune(r)
+			quoted.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&quoted, "\\u%04x", r)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// skipHeredoc returns length of the heredoc data starts with, up to and
+// including its closing marker
+func skipHeredoc(data string) int {
+	i := 2
+	if i < len(data) && data[i] == '-' {
+		i++
+	}
+	start := i
+	for i < len(data) && isIdentifierChar(data[i]) {
+		i++
+	}
+	marker := data[start:i]
+	if marker == "" {
+		return 2
+	}
+
+	for {
+		newline := strings.IndexByte(data[i:], '\n')
+		if newline < 0 {
+			return len(data)
+		}
+		i += newline + 1
+		end := strings.IndexByte(data[i:], '\n')
+		if end < 0 {
+			end = len(data) - i
+		}
+		if strings.TrimSpace(data[i:i+end]) == marker {
+			return i + end
+		}
+	}
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
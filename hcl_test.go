@@ -0,0 +1,90 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanModuleBlocks(t *testing.T) {
+	data := `# module "commented" { source = "./nope" }
+/*
+module "also_commented" {
+  source = "./nope"
+}
+*/
+module "vpc" {
+  // the network
+  source = "./vendor/modules/tf-aws-vpc"
+  name   = "main-${var.env}"
+
+  tags = {
+    source = "not the module source"
+  }
+}
+
+resource "aws_instance" "web" {
+  user_data = <<-EOT
+    module "heredoc" {
+      source = "./nope"
+    }
+  EOT
+}
+
+module "iam" {
+  source  = "terraform-aws-modules/iam/aws"
+  version = "~> 5.0"
+}
+`
+	blocks := scanModuleBlocks(data)
+
+	if assert.Len(t, blocks, 2) {
+		assert.Equal(t, "vpc", blocks[0].Name)
+		assert.Equal(t, "./vendor/modules/tf-aws-vpc", blocks[0].Source)
+		assert.Equal(t, 7, blocks[0].Line)
+		assert.Equal(t, `"./vendor/modules/tf-aws-vpc"`, data[blocks[0].SourceSpan[0]:blocks[0].SourceSpan[1]])
+		assert.Equal(t, "", blocks[0].Version)
+
+		assert.Equal(t, "iam", blocks[1].Name)
+		assert.Equal(t, "terraform-aws-modules/iam/aws", blocks[1].Source)
+		assert.Equal(t, "~> 5.0", blocks[1].Version)
+		assert.Equal(t, 25, blocks[1].Line)
+		assert.Equal(t, `version = "~> 5.0"`, data[blocks[1].VersionSpan[0]:blocks[1].VersionSpan[1]])
+	}
+}
+
+func TestQuoteHCLString(t *testing.T) {
+	for value, expected := range map[string]string{
+		"./vendor/modules/tf-aws-vpc": `"./vendor/modules/tf-aws-vpc"`,
+		`say "hi" \ bye`:              `"say \"hi\" \\ bye"`,
+		"./modules/${var.env}":        `"./modules/$${var.env}"`,
+		"%{if true}x%{endif}":         `"%%{if true}x%%{endif}"`,
+		"$HOME 100% {}":               `"$HOME 100% {}"`,
+		"line\nbreak\ttab\r":          `"line\nbreak\ttab\r"`,
+		"bell\a":                      `"bell\u0007"`,
+	} {
+		quoted := quoteHCLString(value)
+		assert.Equal(t, expected, quoted, value)
+		if value != "bell\a" {
+			unquoted, n := scanHCLString(quoted)
+			assert.Equal(t, value, unquoted)
+			assert.Equal(t, len(quoted), n)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exactVersionPattern matches registry version constraints pinning a single version
+var exactVersionPattern = regexp.MustCompile(`^=?\s*v?(\d+\.\d+\.\d+\S*)$`)
+
+// invalidKeyChars matches characters not allowed in module names
+var invalidKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type importCommand struct {
+	Rewrite bool `long:"rewrite" description:"Rewrite source of imported module blocks to their vendored path"`
+	Write   bool `long:"write" description:"Write the Terrafile and rewritten terraform files instead of only showing them"`
+}
+
+// remoteSource is a module source of a terraform file terrafile can vendor
+type remoteSource struct {
+	// Source and Version as used in a Terrafile
+	Source  string
+	Version string
+	// Subdir is the folder of the module inside the repository
+	Subdir string
+}
+
+// importedModule is a Terrafile entry generated from module blocks
+type importedModule struct {
+	Key          string
+	Source       string
+	Version      string
+	Destinations []string
+}
+
+// importedBlock is a module block whose source is replaced by a vendored module
+type importedBlock struct {
+	File   string
+	Block  moduleBlock
+	Key    string
+	Subdir string
+}
+
+// Execute generates a Terrafile from module blocks of terraform files under
+// the current folder, and optionally points them to the vendored modules
+func (c *importCommand) Execute(_ []string) error {
+	modules, blocks, err := importModules()
+	if err != nil {
+		return err
+	}
+	if len(modules) == 0 {
+		log.Infof("[*] Found no remote module to import")
+		return nil
+	}
+
+	terrafile := formatTerrafile(modules)
+	fmt.Print(terrafile)
+
+	rewritten := make(map[string]string)
+	if c.Rewrite {
+		if rewritten, err = rewriteSources(importEdits(blocks)); err != nil {
+			return err
+		}
+		if err := printRewrites(rewritten); err != nil {
+			return err
+		}
+	}
+
+	if !c.Write {
+		log.Infof("[*] Found %d module(s) in %d module block(s), pass --write to write %s", len(modules), len(blocks), opts.TerrafilePath)
+		return nil
+	}
+
+	if _, err := os.Stat(opts.TerrafilePath); err == nil {
+		return fmt.Errorf("%s already exists, remove it or write to another file with --terrafile_file", opts.TerrafilePath)
+	}
+	if err := os.WriteFile(opts.TerrafilePath, []byte(terrafile), 0644); err != nil {
+		return fmt.Errorf("failed to write %s due to error: %s", opts.TerrafilePath, err)
+	}
+	log.Infof("[*] Wrote %d module(s) to %s", len(modules), opts.TerrafilePath)
+
+	return writeRewrites(rewritten)
+}
+
+// importModules scans terraform files under the current folder for module
+// blocks with remote sources and returns the Terrafile entries for them,
+// ordered by name, and the blocks using them
+func importModules() ([]importedModule, []importedBlock, error) {
+	type entry struct {
+		remoteSource
+		destinations map[string]bool
+	}
+	var entries []*entry
+	var blocks []importedBlock
+	found := make(map[string]*entry)
+
+	err := walkTerraformFiles(func(file string, data string) error {
+		for _, block := range scanModuleBlocks(data) {
+			if block.Source == "" || strings.HasPrefix(block.Source, "./") || strings.HasPrefix(block.Source, "../") {
+				continue
+			}
+			source, err := parseModuleSource(block.Source, block.Version)
+			if err != nil {
+				log.Warnf("[*] %s:%d: skipping module %q, %s", file, block.Line, block.Name, err)
+				continue
+			}
+
+			id := source.Source + "?ref=" + source.Version
+			e, ok := found[id]
+			if !ok {
+				e = &entry{remoteSource: source, destinations: make(map[string]bool)}
+				found[id] = e
+				entries = append(entries, e)
+				if registrySource(block.Source) {
+					log.Warnf("[*] %s:%d: assuming registry module %q is hosted at %s, check the source in %s", file, block.Line, block.Source, source.Source, opts.TerrafilePath)
+				}
+			}
+			e.destinations[filepath.Dir(file)] = true
+			// the key is set once every module is known
+			blocks = append(blocks, importedBlock{File: file, Block: block, Key: id, Subdir: source.Subdir})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to scan terraform files for module blocks due to error: %s", err)
+	}
+
+	// name modules after their repository, adding the version if one
+	// repository is used at several versions
+	byName := make(map[string]int)
+	for _, e := range entries {
+		byName[repositoryName(e.Source)]++
+	}
+	keys := make(map[string]string)
+	taken := make(map[string]bool)
+	var modules []importedModule
+	for _, e := range entries {
+		key := repositoryName(e.Source)
+		if byName[key] > 1 {
+			key += "-" + e.Version
+		}
+		key = invalidKeyChars.ReplaceAllString(key, "-")
+		for base, i := key, 2; taken[key]; i++ {
+			key = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[key] = true
+		keys[e.Source+"?ref="+e.Version] = key
+
+		var destinations []string
+		for d := range e.destinations {
+			destinations = append(destinations, d)
+		}
+		sort.Strings(destinations)
+		// modules used only by the root are installed there by default
+		if len(destinations) == 1 && destinations[0] == "." {
+			destinations = nil
+		}
+		modules = append(modules, importedModule{Key: key, Source: e.Source, Version: e.Version, Destinations: destinations})
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Key < modules[j].Key })
+
+	for i := range blocks {
+		blocks[i].Key = keys[blocks[i].Key]
+	}
+
+	return modules, blocks, nil
+}
+
+// parseModuleSource returns the git repository and version of a module block
+// with source and version. Registry modules are assumed to be hosted on
+// GitHub following the terraform-<provider>-<name> convention.
+func parseModuleSource(source string, version string) (remoteSource, error) {
+	rest := strings.TrimPrefix(source, "git::")
+	switch {
+	case strings.HasPrefix(source, "git::"), strings.HasPrefix(rest, "git@"):
+	case strings.HasPrefix(rest, "github.com/"):
+		rest = "https://" + rest
+	case registrySource(rest):
+		return parseRegistrySource(rest, version)
+	default:
+		return remoteSource{}, fmt.Errorf("source %q is not a git repository", source)
+	}
+
+	rest, query, _ := strings.Cut(rest, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return remoteSource{}, fmt.Errorf("source %q has an invalid query due to error: %s", source, err)
+	}
+	ref := values.Get("ref")
+	if ref == "" {
+		return remoteSource{}, fmt.Errorf("source %q is not pinned to a version with ?ref=", source)
+	}
+
+	// the subdirectory is separated by // after the scheme
+	var subdir string
+	schemeEnd := 0
+	if i := strings.Index(rest, "://"); i >= 0 {
+		schemeEnd = i + 3
+	}
+	if i := strings.Index(rest[schemeEnd:], "//"); i >= 0 {
+		rest, subdir = rest[:schemeEnd+i], strings.Trim(rest[schemeEnd+i+2:], "/")
+	}
+
+	if strings.HasPrefix(rest, "https://github.com/") && !strings.HasSuffix(rest, ".git") {
+		rest += ".git"
+	}
+
+	return remoteSource{Source: rest, Version: ref, Subdir: subdir}, nil
+}
+
+// registrySource reports whether source is a module of the public registry,
+// i.e. namespace/name/provider with an optional subdirectory
+func registrySource(source string) bool {
+	source, _, _ = strings.Cut(source, "//")
+	parts := strings.Split(source, "/")
+	if len(parts) != 3 || strings.Contains(parts[0], ".") || strings.Contains(source, ":") {
+		return false
+	}
+	for _, part := range parts {
+		if !moduleKeyPattern.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRegistrySource(source string, version string) (remoteSource, error) {
+	match := exactVersionPattern.FindStringSubmatch(strings.TrimSpace(version))
+	if match == nil {
+		return remoteSource{}, fmt.Errorf("registry module %q needs a version pinning a single release, got %q", source, version)
+	}
+
+	source, subdir, _ := strings.Cut(source, "//")
+	parts := strings.Split(source, "/")
+	return remoteSource{
+		Source:  fmt.Sprintf("https://github.com/%s/terraform-%s-%s.git", parts[0], parts[2], parts[1]),
+		Version: "v" + match[1],
+		Subdir:  strings.Trim(subdir, "/"),
+	}, nil
+}
+
+// repositoryName returns the name of the repository at source, without .git
+func repositoryName(source string) string {
+	source = strings.TrimSuffix(strings.TrimRight(source, "/"), ".git")
+	if i := strings.LastIndexAny(source, "/:"); i >= 0 {
+		source = source[i+1:]
+	}
+	return source
+}
+
+// formatTerrafile returns a Terrafile defining modules
+func formatTerrafile(modules []importedModule) string {
+	var b strings.Builder
+	for i, m := range modules {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s:\n", m.Key)
+		fmt.Fprintf(&b, "  source: %s\n", strconv.Quote(m.Source))
+		fmt.Fprintf(&b, "  version: %s\n", strconv.Quote(m.Version))
+		if len(m.Destinations) > 0 {
+			b.WriteString("  destinations:\n")
+			for _, d := range m.Destinations {
+				fmt.Fprintf(&b, "    - %s\n", strconv.Quote(filepath.ToSlash(d)))
+			}
+		}
+	}
+	return b.String()
+}
+
+// importEdits returns the edits pointing imported module blocks to their
+// vendored module
+func importEdits(blocks []importedBlock) []sourceEdit {
+	modulePath := path.Clean(filepath.ToSlash(opts.ModulePath))
+
+	edits := make([]sourceEdit, 0, len(blocks))
+	for _, b := range blocks {
+		edits = append(edits, sourceEdit{File: b.File, Block: b.Block, Source: "./" + path.Join(modulePath, b.Key, b.Subdir)})
+	}
+	return edits
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseModuleSource(t *testing.T) {
+	for name, test := range map[string]struct {
+		source, version string
+		expected        remoteSource
+		err             bool
+	}{
+		"git https":         {source: "git::https://github.com/org/tf-aws-vpc.git?ref=v1.0.0", expected: remoteSource{Source: "https://github.com/org/tf-aws-vpc.git", Version: "v1.0.0"}},
+		"git ssh subdir":    {source: "git::ssh://git@example.com/org/tf-aws-iam.git//modules/role?ref=v2.0.0", expected: remoteSource{Source: "ssh://git@example.com/org/tf-aws-iam.git", Version: "v2.0.0", Subdir: "modules/role"}},
+		"scp-like":          {source: "git@github.com:org/tf-aws-vpc.git?ref=v1.0.0", expected: remoteSource{Source: "git@github.com:org/tf-aws-vpc.git", Version: "v1.0.0"}},
+		"github shorthand":  {source: "github.com/org/tf-aws-vpc?ref=v1.0.0", expected: remoteSource{Source: "https://github.com/org/tf-aws-vpc.git", Version: "v1.0.0"}},
+		"registry":          {source: "terraform-aws-modules/vpc/aws", version: "5.1.0", expected: remoteSource{Source: "https://github.com/terraform-aws-modules/terraform-aws-vpc.git", Version: "v5.1.0"}},
+		"registry subdir":   {source: "terraform-aws-modules/iam/aws//modules/iam-role", version: "= 5.1.0", expected: remoteSource{Source: "https://github.com/terraform-aws-modules/terraform-aws-iam.git", Version: "v5.1.0", Subdir: "modules/iam-role"}},
+		"registry range":    {source: "terraform-aws-modules/vpc/aws", version: "~> 5.0", err: true},
+		"registry unpinned": {source: "terraform-aws-modules/vpc/aws", err: true},
+		"git unpinned":      {source: "git::https://github.com/org/tf-aws-vpc.git", err: true},
+		"private registry":  {source: "app.terraform.io/org/vpc/aws", version: "1.0.0", err: true},
+		"archive":           {source: "https://example.com/vpc.zip", err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			source, err := parseModuleSource(test.source, test.version)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, source)
+		})
+	}
+}
+
+func TestImportModules(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{"stacks/networking", "stacks/onboarding"} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+	createFile(t, "main.tf", "module \"vpc\" {\n  source = \"git::https://github.com/org/tf-aws-vpc.git?ref=v2.0.0\"\n}\n")
+	createFile(t, "stacks/networking/main.tf", `module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws"
+  version = "5.1.0"
+
+  name = "networking"
+}
+
+module "local" {
+  source = "./modules/local"
+}
+`)
+	createFile(t, "stacks/onboarding/main.tf", `module "role" {
+  source = "git::https://github.com/org/tf-aws-iam.git//modules/role?ref=v1.0.0"
+}
+
+module "vpc" {
+  source = "git::https://github.com/org/tf-aws-vpc.git?ref=v1.0.0"
+}
+
+module "policy" {
+  source = "git::https://github.com/org/tf-aws-iam.git//modules/policy?ref=v1.0.0"
+}
+`)
+
+	modules, blocks, err := importModules()
+	assert.NoError(t, err)
+	assert.Equal(t, []importedModule{
+		{Key: "terraform-aws-vpc", Source: "https://github.com/terraform-aws-modules/terraform-aws-vpc.git", Version: "v5.1.0", Destinations: []string{"stacks/networking"}},
+		{Key: "tf-aws-iam", Source: "https://github.com/org/tf-aws-iam.git", Version: "v1.0.0", Destinations: []string{"stacks/onboarding"}},
+		{Key: "tf-aws-vpc-v1.0.0", Source: "https://github.com/org/tf-aws-vpc.git", Version: "v1.0.0", Destinations: []string{"stacks/onboarding"}},
+		{Key: "tf-aws-vpc-v2.0.0", Source: "https://github.com/org/tf-aws-vpc.git", Version: "v2.0.0"},
+	}, modules)
+	assert.Len(t, blocks, 5)
+
+	assert.Equal(t, `terraform-aws-vpc:
+  source: "https://github.com/terraform-aws-modules/terraform-aws-vpc.git"
+  version: "v5.1.0"
+  destinations:
+    - "stacks/networking"
+
+tf-aws-iam:
+  source: "https://github.com/org/tf-aws-iam.git"
+  version: "v1.0.0"
+  destinations:
+    - "stacks/onboarding"
+
+tf-aws-vpc-v1.0.0:
+  source: "https://github.com/org/tf-aws-vpc.git"
+  version: "v1.0.0"
+  destinations:
+    - "stacks/onboarding"
+
+tf-aws-vpc-v2.0.0:
+  source: "https://github.com/org/tf-aws-vpc.git"
+  version: "v2.0.0"
+`, formatTerrafile(modules))
+
+	rewritten, err := rewriteSources(importEdits(blocks))
+	assert.NoError(t, err)
+	assert.Equal(t, `module "vpc" {
+  source  = "./vendor/modules/terraform-aws-vpc"
+
+  name = "networking"
+}
+
+module "local" {
+  source = "./modules/local"
+}
+`, rewritten["stacks/networking/main.tf"])
+	assert.Equal(t, `module "role" {
+  source = "./vendor/modules/tf-aws-iam/modules/role"
+}
+
+module "vpc" {
+  source = "./vendor/modules/tf-aws-vpc-v1.0.0"
+}
+
+module "policy" {
+  source = "./vendor/modules/tf-aws-iam/modules/policy"
+}
+`, rewritten["stacks/onboarding/main.tf"])
+	assert.Equal(t, "module \"vpc\" {\n  source = \"./vendor/modules/tf-aws-vpc-v2.0.0\"\n}\n", rewritten["main.tf"])
+}
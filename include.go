@@ -0,0 +1,294 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// referenceFields lists every field a reference to a file in git may have
+var referenceFields = []string{"source", "version", "path"}
+
+// fileReference points to a file the Terrafile depends on, like an entry of
+// `include:`, either a local path or a file at path in version of git
+// repository source
+type fileReference struct {
+	Source  string `yaml:"source"`
+	Version string `yaml:"version"`
+	Path    string `yaml:"path"`
+}
+
+// UnmarshalYAML accepts a plain path as well as a mapping
+func (r *fileReference) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		r.Path = node.Value
+		return nil
+	}
+
+	type plain fileReference
+	return node.Decode((*plain)(r))
+}
+
+// terrafileLocation is a Terrafile read by the loader, root is the checkout of
+// source the file was found in or empty for local files
+type terrafileLocation struct {
+	file    string
+	source  string
+	version string
+	root    string
+}
+
+// origin describes the location for humans, git locations are written the
+// way terraform writes sources with a subdirectory and a ref
+func (l terrafileLocation) origin() string {
+	if l.source == "" {
+		return filepath.Clean(l.file)
+	}
+	rel, err := filepath.Rel(l.root, l.file)
+	if err != nil {
+		rel = filepath.Base(l.file)
+	}
+	return fmt.Sprintf("%s//%s?ref=%s", l.source, filepath.ToSlash(rel), l.version)
+}
+
+// includeLoader reads a Terrafile together with every file it includes
+type includeLoader struct {
+	// origins of files being loaded, to detect include cycles
+	loading []string
+	// mirrors makes mirror rules of the first file apply before the files it
+	// includes are fetched
+	mirrors bool
+	// catalog is the one of the first file, versions of its profiles are
+	// resolved with it
+	catalog *versionCatalog
+}
+
+// load reads the file at location and every file it includes, merging their
+// modules. Modules of a file take precedence over the included ones, but only
+// if they are marked with `override: true` when source or version differ. Two
+// included files defining the same module differently is an error. Versions
+// are resolved with the catalog of the file, or the one of the including file.
+// Only the settings of the file at location itself are returned.
+func (l *includeLoader) load(location terrafileLocation, catalog *versionCatalog) (map[string]module, terrafileSettings, []error) {
+	origin := location.origin()
+	for _, loading := range l.loading {
+		if loading == origin {
+			return nil, terrafileSettings{}, []error{fmt.Errorf("include cycle: %s -> %s", strings.Join(l.loading, " -> "), origin)}
+		}
+	}
+	l.loading = append(l.loading, origin)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	config, settings, errs := readTerrafile(location.file)
+	if len(errs) > 0 {
+		return nil, settings, errs
+	}
+	for key, m := range config {
+		m.Origin = origin
+		config[key] = m
+	}
+	applyModuleDefaults(config, settings)
+	if l.mirrors && len(l.loading) == 1 {
+		mirrorRules = append(mirrorRules, settings.Mirrors...)
+	}
+
+	if settings.Catalog != nil {
+		catalog, errs = loadCatalog(location, *settings.Catalog)
+		if len(errs) > 0 {
+			return nil, settings, errs
+		}
+	}
+	if errs = resolveVersions(config, catalog); len(errs) > 0 {
+		return nil, settings, errs
+	}
+	if len(l.loading) == 1 {
+		l.catalog = catalog
+	}
+
+	included := make(map[string]module)
+	for _, include := range settings.Include {
+		modules, includeErrs := l.loadInclude(location, include, catalog)
+		if len(includeErrs) > 0 {
+			return nil, settings, includeErrs
+		}
+		for _, key := range sortedKeys(modules) {
+			m := modules[key]
+			if existing, ok := included[key]; ok && !sameModule(existing, m) {
+				errs = append(errs, fmt.Errorf("module %q is defined as %s of %s in %s and as %s of %s in %s, define it with `override: true` in %s to choose one",
+					key, existing.Version, existing.Source, existing.Origin, m.Version, m.Source, m.Origin, origin))
+				continue
+			}
+			if _, ok := included[key]; !ok {
+				included[key] = m
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		if existing, ok := included[key]; ok && !sameModule(existing, m) && !m.Override {
+			errs = append(errs, fmt.Errorf("module %q is defined as %s of %s in %s and as %s of %s in %s, set `override: true` in %s to override it",
+				key, m.Version, m.Source, origin, existing.Version, existing.Source, existing.Origin, origin))
+			continue
+		}
+		included[key] = m
+	}
+	if len(errs) > 0 {
+		return nil, settings, errs
+	}
+
+	return included, settings, nil
+}
+
+// loadInclude reads the Terrafile include of the file at location points to
+func (l *includeLoader) loadInclude(location terrafileLocation, include fileReference, catalog *versionCatalog) (map[string]module, []error) {
+	included, cleanup, err := resolveReference(location, include, "Terrafile")
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer cleanup()
+
+	modules, _, errs := l.load(included, catalog)
+	return modules, errs
+}
+
+// resolveReference returns location of the file ref of the file at location
+// points to, fetching it if it is in git. Local paths are relative to the file
+// at location and can't leave the checkout it was found in. Cleanup removes
+// the checkout once the file isn't needed anymore.
+func resolveReference(location terrafileLocation, ref fileReference, defaultPath string) (terrafileLocation, func(), error) {
+	if ref.Source == "" {
+		path := filepath.Join(filepath.Dir(location.file), ref.Path)
+		if location.root != "" && !insideDir(location.root, path) {
+			return terrafileLocation{}, nil, fmt.Errorf("%s: %q points outside of %s", location.origin(), ref.Path, location.source)
+		}
+		return terrafileLocation{file: path, source: location.source, version: location.version, root: location.root}, func() {}, nil
+	}
+
+	checkout, err := os.MkdirTemp("", "terrafile-reference-")
+	if err != nil {
+		return terrafileLocation{}, nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(checkout) }
+
+	if err := gitClone(ref.Source, ref.Version, "checkout", checkout); err != nil {
+		cleanup()
+		return terrafileLocation{}, nil, fmt.Errorf("%s: failed to fetch %s due to error: %s", location.origin(), ref.Source, err)
+	}
+	root := filepath.Join(checkout, "checkout")
+
+	path := ref.Path
+	if path == "" {
+		path = defaultPath
+	}
+	file := filepath.Join(root, path)
+	if !insideDir(root, file) {
+		cleanup()
+		return terrafileLocation{}, nil, fmt.Errorf("%s: path %q points outside of %s", location.origin(), ref.Path, ref.Source)
+	}
+
+	return terrafileLocation{file: file, source: ref.Source, version: ref.Version, root: root}, cleanup, nil
+}
+
+// insideDir reports whether path is dir or inside of it, without resolving symlinks
+func insideDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sameModule reports whether a and b fetch the same version of the same source
+func sameModule(a module, b module) bool {
+	return a.Source == b.Source && a.Version == b.Version
+}
+
+func sortedKeys(config map[string]module) []string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *validator) validateIncludes(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "field \"include\" of %q section must be a list of paths or git sources", settingsKey)
+		return
+	}
+
+	for _, include := range node.Content {
+		v.validateReference("include", include)
+	}
+}
+
+// validateReference checks node is a path or a mapping pointing to a file in
+// git, what names the field for messages
+func (v *validator) validateReference(what string, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if strings.TrimSpace(node.Value) == "" {
+			v.errorf(node, "%s must not be empty", what)
+		}
+		return
+	case yaml.MappingNode:
+	default:
+		v.errorf(node, "%s must be a path or a mapping with %s fields", what, strings.Join(referenceFields, ", "))
+		return
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fieldNode, valueNode := node.Content[i], node.Content[i+1]
+		field := fieldNode.Value
+
+		if _, ok := fields[field]; ok {
+			v.errorf(fieldNode, "duplicate field %q in %s", field, what)
+			continue
+		}
+		fields[field] = valueNode
+
+		if !contains(referenceFields, field) {
+			message := fmt.Sprintf("unknown field %q in %s", field, what)
+			if suggestion := closest(field, referenceFields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.errorf(fieldNode, "%s", message)
+			continue
+		}
+		if valueNode.Kind != yaml.ScalarNode || strings.TrimSpace(valueNode.Value) == "" {
+			v.errorf(valueNode, "field %q of %s must be a non-empty string", field, what)
+		}
+	}
+
+	_, hasSource := fields["source"]
+	_, hasVersion := fields["version"]
+	_, hasPath := fields["path"]
+	switch {
+	case hasSource && !hasVersion:
+		v.errorf(node, "%s of %s is missing required field \"version\"", what, fields["source"].Value)
+	case !hasSource && hasVersion:
+		v.errorf(node, "%s with a version is missing required field \"source\"", what)
+	case !hasSource && !hasPath:
+		v.errorf(node, "%s must have a source or a path", what)
+	}
+}
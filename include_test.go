@@ -0,0 +1,174 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTerrafileIncludes(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+	opts.TerrafilePath = "Terrafile"
+
+	// baseline published in a repository of the platform team
+	repository := t.TempDir()
+	createFile(t, filepath.Join(repository, "Terrafile"), `tf-aws-iam:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-iam"
+  version: "v1.0.0"
+`)
+	createGitModule(t, repository)
+	_, err := gitOutput(repository, "tag", "v1.0.0")
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.MkdirAll("platform", os.ModePerm))
+	createFile(t, "platform/Terrafile", `tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+tf-aws-s3-bucket:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-s3-bucket"
+  version: "v2.0.0"
+`)
+	createFile(t, "Terrafile", `terrafile:
+  include:
+    - platform/Terrafile
+    - source: "file://`+filepath.ToSlash(repository)+`"
+      version: v1.0.0
+tf-aws-s3-bucket:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-s3-bucket"
+  version: "v2.0.0"
+`)
+
+	config, errs := loadTerrafileErrors(t)
+	assert.Empty(t, errs)
+	origins := make(map[string]string)
+	for key, m := range config {
+		origins[key] = m.Origin
+	}
+	assert.Equal(t, map[string]string{
+		"tf-aws-vpc":       "platform/Terrafile",
+		"tf-aws-s3-bucket": "Terrafile",
+		"tf-aws-iam":       "file://" + filepath.ToSlash(repository) + "//Terrafile?ref=v1.0.0",
+	}, origins)
+
+	// a different version is a conflict, unless it is an explicit override
+	createFile(t, "Terrafile", `terrafile:
+  include:
+    - platform/Terrafile
+tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v2.0.0"
+`)
+	_, errs = loadTerrafileErrors(t)
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0], `module "tf-aws-vpc" is defined as v2.0.0 of`)
+	}
+
+	createFile(t, "Terrafile", `terrafile:
+  include:
+    - platform/Terrafile
+tf-aws-vpc:
+  source:   "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version:  "v2.0.0"
+  override: true
+`)
+	config, errs = loadTerrafileErrors(t)
+	assert.Empty(t, errs)
+	assert.Equal(t, "v2.0.0", config["tf-aws-vpc"].Version)
+
+	// included files must agree with each other
+	createFile(t, "other.Terrafile", `tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.0.0"
+`)
+	createFile(t, "Terrafile", `terrafile:
+  include:
+    - platform/Terrafile
+    - other.Terrafile
+`)
+	_, errs = loadTerrafileErrors(t)
+	assert.Len(t, errs, 1)
+}
+
+func TestLoadTerrafileIncludeCycle(t *testing.T) {
+	defer restoreOpts()()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+	opts.TerrafilePath = "Terrafile"
+
+	createFile(t, "Terrafile", "terrafile:\n  include: [other.Terrafile]\n")
+	createFile(t, "other.Terrafile", "terrafile:\n  include: [Terrafile]\n")
+
+	_, errs := loadTerrafileErrors(t)
+	assert.Equal(t, []string{"include cycle: Terrafile -> other.Terrafile -> Terrafile"}, errs)
+}
+
+func TestParseTerrafileIncludeErrors(t *testing.T) {
+	_, _, errs := parseTerrafile("Terrafile", []byte(`terrafile:
+  include:
+    - ""
+    - source: "git@github.com:platform/baseline"
+    - sourc: "git@github.com:platform/baseline"
+      version: v1.0.0
+`))
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		`Terrafile:3:7: include must not be empty`,
+		`Terrafile:4:7: include of git@github.com:platform/baseline is missing required field "version"`,
+		`Terrafile:5:7: unknown field "sourc" in include, did you mean "source"?`,
+		`Terrafile:5:7: include with a version is missing required field "source"`,
+	}, messages)
+}
+
+// loadTerrafileErrors loads the Terrafile of the run, returning the problems
+// loadTerrafile logs instead of the error counting them
+func loadTerrafileErrors(t *testing.T) (map[string]module, []string) {
+	hook := &errorHook{}
+	hooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(hooks)
+	log.AddHook(hook)
+
+	config, err := loadTerrafile()
+	assert.Equal(t, err != nil, len(hook.messages) > 0, "%v", err)
+	return config, hook.messages
+}
+
+// errorHook collects messages logged as errors
+type errorHook struct {
+	messages []string
+}
+
+func (h *errorHook) Levels() []log.Level {
+	return []log.Level{log.ErrorLevel}
+}
+
+func (h *errorHook) Fire(entry *log.Entry) error {
+	h.messages = append(h.messages, entry.Message)
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolationPattern matches `$$`, `${VAR}` and `${VAR:-default}`
+var interpolationPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateNode replaces variables in every scalar value of the document,
+// mapping keys are left as they are
+func (v *validator) interpolateNode(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			v.interpolateNode(child)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			v.interpolateNode(node.Content[i])
+		}
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value, lookupVariable)
+		if err != nil {
+			v.errorf(node, "%s", err)
+			return
+		}
+		node.Value = value
+	}
+}
+
+// interpolate replaces `${VAR}` in s with value of VAR and `${VAR:-default}`
+// with default if VAR is unset or empty. `$$` is replaced with a single `$`.
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var err error
+	result := interpolationPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		groups := interpolationPattern.FindStringSubmatch(match)
+		name, hasDefault, fallback := groups[1], groups[2] != "", groups[3]
+
+		value, ok := lookup(name)
+		switch {
+		case ok && value != "":
+			return value
+		case hasDefault:
+			return fallback
+		case ok:
+			return value
+		}
+
+		if err == nil {
+			err = fmt.Errorf("variable %s is not defined, set it in the environment, with --var %s=VALUE or use ${%s:-default}", name, name, name)
+		}
+		return match
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// anything left over can't be interpolated
+	if rest := interpolationPattern.ReplaceAllString(s, ""); strings.Contains(rest, "${") {
+		return "", fmt.Errorf("invalid variable reference in %q, expected ${VAR} or ${VAR:-default}", s)
+	}
+
+	return result, nil
+}
+
+// lookupVariable returns value of variable name set with --var or in the environment
+func lookupVariable(name string) (string, bool) {
+	// the last --var of a name wins
+	for i := len(opts.Vars) - 1; i >= 0; i-- {
+		if key, value, ok := strings.Cut(opts.Vars[i], "="); ok && key == name {
+			return value, true
+		}
+	}
+	return os.LookupEnv(name)
+}
+
+// checkVars makes sure every --var is in KEY=VALUE form
+func checkVars() error {
+	for _, v := range opts.Vars {
+		if key, _, ok := strings.Cut(v, "="); !ok || key == "" {
+			return fmt.Errorf("invalid --var %q, expected KEY=VALUE", v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, ok := map[string]string{"GIT_HOST": "github.com", "EMPTY": ""}[name]
+		return value, ok
+	}
+
+	for s, expected := range map[string]string{
+		"git@${GIT_HOST}:platform/tf-vpc":  "git@github.com:platform/tf-vpc",
+		"${VPC_VERSION:-v1.46.0}":          "v1.46.0",
+		"${GIT_HOST:-example.com}":         "github.com",
+		"${EMPTY:-default}":                "default",
+		"${EMPTY}":                         "",
+		"$$HOME and ${GIT_HOST}":           "$HOME and github.com",
+		"no variables":                     "no variables",
+		"${GIT_HOST}/${VPC_VERSION:-v1.0}": "github.com/v1.0",
+	} {
+		actual, err := interpolate(s, lookup)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, actual, s)
+	}
+
+	for _, s := range []string{"${VPC_VERSION}", "${GIT_HOST", "${1INVALID}"} {
+		_, err := interpolate(s, lookup)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseTerrafileInterpolation(t *testing.T) {
+	defer restoreOpts()()
+	t.Setenv("GIT_HOST", "github.com")
+	opts.Vars = []string{"VPC_VERSION=v1.0.0", "VPC_VERSION=v2.0.0"}
+
+	config, _, errs := parseTerrafile("Terrafile", []byte(`tf-aws-vpc:
+  source:  "git@${GIT_HOST}:terraform-aws-modules/terraform-aws-vpc"
+  version: "${VPC_VERSION:-v1.46.0}"
+  destinations:
+    - ${STACK:-networking}
+`))
+	assert.Empty(t, errs)
+	assert.Equal(t, module{
+		Source:       "git@github.com:terraform-aws-modules/terraform-aws-vpc",
+		Version:      "v2.0.0",
+		Destinations: []string{"networking"},
+	}, config["tf-aws-vpc"])
+
+	_, _, errs = parseTerrafile("Terrafile", []byte(`tf-aws-vpc:
+  source:  "git@${MIRROR_HOST}:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+`))
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "Terrafile:2:12: variable MIRROR_HOST is not defined, set it in the environment, with --var MIRROR_HOST=VALUE or use ${MIRROR_HOST:-default}", errs[0].Error())
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkModule(t *testing.T) {
+	defer restoreOpts()()
+	root := t.TempDir()
+	src := filepath.Join(root, "vendor/modules/tf-aws-vpc")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "scripts"), os.ModePerm))
+	createFile(t, filepath.Join(src, "main.tf"), "# module\n")
+	createFile(t, filepath.Join(src, "scripts/run.sh"), "#!/bin/sh\n")
+	assert.NoError(t, os.Chmod(filepath.Join(src, "scripts/run.sh"), 0755))
+	assert.NoError(t, os.Symlink("main.tf", filepath.Join(src, "link.tf")))
+
+	for mode, check := range map[string]func(dst string){
+		"symlink": func(dst string) {
+			target, err := os.Readlink(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, src, target)
+		},
+		"relative": func(dst string) {
+			target, err := os.Readlink(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join("..", "..", "..", "vendor", "modules", "tf-aws-vpc"), target)
+			assert.FileExists(t, filepath.Join(dst, "main.tf"))
+		},
+		"copy": func(dst string) {
+			info, err := os.Lstat(dst)
+			assert.NoError(t, err)
+			assert.True(t, info.IsDir())
+			info, err = os.Stat(filepath.Join(dst, "scripts/run.sh"))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+			target, err := os.Readlink(filepath.Join(dst, "link.tf"))
+			assert.NoError(t, err)
+			assert.Equal(t, "main.tf", target)
+		},
+	} {
+		t.Run(mode, func(t *testing.T) {
+			opts.LinkMode = mode
+			dst := filepath.Join(root, mode, "vendor/modules")
+			assert.NoError(t, os.MkdirAll(dst, os.ModePerm))
+			dst = filepath.Join(dst, "tf-aws-vpc")
+
+			assert.NoError(t, linkModule(src, dst))
+			check(dst)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+type listCommand struct{}
+
+// Execute prints every selected module together with the file it is defined
+// in, which tells modules of included files apart
+func (c *listCommand) Execute(_ []string) error {
+	config, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	config, err = selectModules(config)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tSOURCE\tVERSION\tFILE")
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, m.Source, m.Version, m.Origin)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockSuffix is appended to the name of the Terrafile to get name of its lockfile
+const lockSuffix = ".lock"
+
+// lockfile records how modules of the Terrafile were resolved when they were
+// last installed, separately for every profile
+type lockfile struct {
+	Profiles map[string]map[string]lockedModule `json:"profiles"`
+}
+
+// lockedModule is the resolution of a single module
+type lockedModule struct {
+	Source  string `json:"source"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	// Hash of the installed files, see hashModule
+	Hash string `json:"hash,omitempty"`
+	// Dependencies are the modules the module uses, vendored by --transitive
+	Dependencies []string `json:"dependencies,omitempty"`
+	// DerivedFrom lists the modules using a module vendored by --transitive
+	DerivedFrom []string `json:"derived_from,omitempty"`
+}
+
+// lockPath returns name of the lockfile of the Terrafile at terrafilePath
+func lockPath(terrafilePath string) string {
+	return terrafilePath + lockSuffix
+}
+
+// readLock reads the lockfile named filename, a missing lockfile is empty
+func readLock(filename string) (*lockfile, error) {
+	lock := &lockfile{Profiles: make(map[string]map[string]lockedModule)}
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s due to error: %s", filename, err)
+	}
+	if lock.Profiles == nil {
+		lock.Profiles = make(map[string]map[string]lockedModule)
+	}
+
+	return lock, nil
+}
+
+// writeLock writes lock to the file named filename
+func writeLock(filename string, lock *lockfile) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// updateLock records resolutions of installed modules under the active
+// profile. Entries of modules no longer in allModules are dropped, those of
+// modules left out of the selection are kept. Overridden modules are never
+// recorded, as they only exist on the machine of a single developer, and
+// their entries are kept as they are.
+func updateLock(lock *lockfile, allModules map[string]module, installed map[string]module) error {
+	profile := activeProfile()
+	entries := lock.Profiles[profile]
+	if entries == nil {
+		entries = make(map[string]lockedModule)
+	}
+
+	for key := range entries {
+		if _, ok := allModules[key]; !ok {
+			delete(entries, key)
+		}
+	}
+
+	for key, m := range installed {
+		if m.Overridden {
+			continue
+		}
+
+		cloneDestination, _ := moduleDestinations(m)
+		metadata, err := readMetadata(filepath.Join(cloneDestination, key))
+		if err != nil {
+			return err
+		}
+		if metadata == nil {
+			return fmt.Errorf("module %s has no metadata in %s", key, filepath.Join(cloneDestination, key))
+		}
+
+		entry := lockedModule{Source: metadata.Source, Version: metadata.Version, Commit: metadata.Commit, Hash: metadata.Hash, DerivedFrom: m.DerivedFrom}
+		for _, dependency := range metadata.Dependencies {
+			entry.Dependencies = append(entry.Dependencies, dependency.Key)
+		}
+		entries[key] = entry
+	}
+
+	lock.Profiles[profile] = entries
+	return nil
+}
+
+// recordLock updates the lockfile of the Terrafile with resolutions of installed modules
+func recordLock(allModules map[string]module, installed map[string]module) error {
+	filename := lockPath(opts.TerrafilePath)
+	lock, err := readLock(filename)
+	if err != nil {
+		return err
+	}
+	if err := updateLock(lock, allModules, installed); err != nil {
+		return err
+	}
+	return writeLock(filename, lock)
+}
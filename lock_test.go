@@ -0,0 +1,100 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordLock(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.TerrafilePath = "Terrafile"
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	source := createGitRepository(t, "v1.0.0", "v2.0.0")
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+
+	config := map[string]module{"tf-aws-vpc": {Source: source, Version: "v1.0.0"}}
+	assert.NoError(t, installModule("tf-aws-vpc", config["tf-aws-vpc"], opts.ModulePath))
+	assert.NoError(t, recordLock(config, config))
+
+	opts.Profile = "dev"
+	config["tf-aws-vpc"] = module{Source: source, Version: "v2.0.0"}
+	assert.NoError(t, installModule("tf-aws-vpc", config["tf-aws-vpc"], opts.ModulePath))
+	assert.NoError(t, recordLock(config, config))
+
+	lock, err := readLock("Terrafile.lock")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default", "dev"}, mapKeysOf(lock.Profiles))
+	assert.Equal(t, "v1.0.0", lock.Profiles["default"]["tf-aws-vpc"].Version)
+	assert.Equal(t, "v2.0.0", lock.Profiles["dev"]["tf-aws-vpc"].Version)
+	assert.Len(t, lock.Profiles["dev"]["tf-aws-vpc"].Commit, 40)
+
+	// overridden modules aren't recorded, their committed resolution is kept
+	locked := lock.Profiles["dev"]["tf-aws-vpc"]
+	config["tf-aws-vpc"] = module{Source: "../terraform-aws-vpc", Version: "fix-policy", Overridden: true}
+	assert.NoError(t, recordLock(config, config))
+	lock, err = readLock("Terrafile.lock")
+	assert.NoError(t, err)
+	assert.Equal(t, locked, lock.Profiles["dev"]["tf-aws-vpc"])
+
+	// modules removed from the Terrafile are dropped
+	assert.NoError(t, recordLock(map[string]module{}, map[string]module{}))
+	lock, err = readLock("Terrafile.lock")
+	assert.NoError(t, err)
+	assert.Empty(t, lock.Profiles["dev"])
+	assert.NotEmpty(t, lock.Profiles["default"])
+}
+
+func TestUpdateLockDependencies(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	config := map[string]module{
+		"tf-aws-iam":      {Source: "https://example.com/tf-aws-iam.git", Version: "v1.0.0"},
+		"tf-label-v0.1.0": {Source: "https://example.com/tf-label.git", Version: "v0.1.0", DerivedFrom: []string{"tf-aws-iam"}},
+	}
+	assert.NoError(t, os.MkdirAll("vendor/modules/tf-aws-iam", os.ModePerm))
+	assert.NoError(t, os.MkdirAll("vendor/modules/tf-label-v0.1.0", os.ModePerm))
+	assert.NoError(t, writeMetadata("vendor/modules/tf-aws-iam", moduleMetadata{Source: config["tf-aws-iam"].Source, Version: "v1.0.0", Commit: "1111",
+		Dependencies: []moduleDependency{{Key: "tf-label-v0.1.0", Source: config["tf-label-v0.1.0"].Source, Version: "v0.1.0"}}}))
+	assert.NoError(t, writeMetadata("vendor/modules/tf-label-v0.1.0", moduleMetadata{Source: config["tf-label-v0.1.0"].Source, Version: "v0.1.0", Commit: "2222"}))
+
+	lock := &lockfile{Profiles: make(map[string]map[string]lockedModule)}
+	assert.NoError(t, updateLock(lock, config, config))
+	assert.Equal(t, map[string]lockedModule{
+		"tf-aws-iam":      {Source: "https://example.com/tf-aws-iam.git", Version: "v1.0.0", Commit: "1111", Dependencies: []string{"tf-label-v0.1.0"}},
+		"tf-label-v0.1.0": {Source: "https://example.com/tf-label.git", Version: "v0.1.0", Commit: "2222", DerivedFrom: []string{"tf-aws-iam"}},
+	}, lock.Profiles[defaultProfile])
+}
+
+func mapKeysOf(m map[string]map[string]lockedModule) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
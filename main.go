@@ -17,22 +17,38 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sync"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/nritholtz/stdemuxerhook"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
 type module struct {
 	Source       string   `yaml:"source"`
 	Version      string   `yaml:"version"`
 	Destinations []string `yaml:"destinations"`
+	Include      []string `yaml:"include"`
+	Exclude      []string `yaml:"exclude"`
+	Tags         []string `yaml:"tags"`
+	Override     bool     `yaml:"override"`
+	Enabled      *bool    `yaml:"enabled"`
+
+	// Origin is the Terrafile the module is defined in
+	Origin string `yaml:"-"`
+	// Overridden is set for modules redirected by the override file
+	Overridden bool `yaml:"-"`
+	// LocalPath is the local checkout the module is linked from instead of fetching it
+	LocalPath string `yaml:"-"`
+	// DestinationNotes explains where destinations which weren't listed came
+	// from, like the glob pattern they were expanded from
+	DestinationNotes map[string]string `yaml:"-"`
+	// DerivedFrom lists the modules using a module vendored by --transitive
+	DerivedFrom []string `yaml:"-"`
 }
 
 var opts struct {
@@ -41,8 +57,71 @@ var opts struct {
 	TerrafilePath string `short:"f" long:"terrafile_file" default:"./Terrafile" description:"File path to the Terrafile file"`
 
 	Clean bool `short:"c" long:"clean" description:"Remove everything from destinations and module path upon fetching module(s)\n !!! WARNING !!! Removes all files and folders in the destinations including non-modules."`
+
+	Vars []string `long:"var" description:"Set variable used in ${VAR} references of the Terrafile in KEY=VALUE form, overrides environment variables, can be repeated"`
+
+	OnlyModules []string `long:"only" description:"Process only modules with these names, comma separated or repeated"`
+
+	ExcludeModules []string `long:"exclude" description:"Skip modules with these names, comma separated or repeated"`
+
+	Tags []string `long:"tags" description:"Process only modules with any of these tags, comma separated or repeated"`
+
+	OnlyDestinations []string `long:"destination" description:"Process only these destinations, comma separated or repeated, '.' selects modules without destinations"`
+
+	Profile string `long:"profile" env:"TERRAFILE_PROFILE" description:"Apply changes of this profile of the Terrafile"`
+
+	Force bool `long:"force" description:"Replace vendored modules even if they contain local modifications"`
+
+	Stash bool `long:"stash" description:"Save local modifications of vendored modules as patch files before replacing them"`
+
+	StashDir string `long:"stash_dir" default:"./.terrafile-stash" description:"Folder to write patch files to when --stash is used"`
+
+	LinkMode string `long:"link_mode" default:"symlink" choice:"symlink" choice:"relative" choice:"copy" description:"How modules are made available in destinations other than the first one: absolute symlink, relative symlink or a copy"`
+
+	Concurrency int `long:"concurrency" default:"0" description:"Maximum number of modules fetched at the same time, 0 fetches all of them at once"`
+
+	ModuleManifest bool `long:"module_manifest" description:"Write terraform's module manifest .terraform/modules/modules.json into every destination, so that terraform init uses vendored modules for remote sources instead of fetching them"`
+
+	Nested bool `long:"nested" description:"Also install modules of Terrafiles shipped by fetched modules into the module path of those modules"`
+
+	NestedDepth int `long:"nested_depth" default:"5" description:"Maximum number of levels of Terrafiles shipped by fetched modules"`
+
+	NestedConflicts string `long:"nested_conflicts" default:"fail" choice:"fail" choice:"highest" description:"What to do if Terrafiles shipped by fetched modules require a module at different versions: fail, or pick the highest version"`
+
+	Transitive bool `long:"transitive" description:"Also vendor remote modules used by vendored modules next to them and point their sources to the vendored copies"`
+
+	UserConfig string `long:"user_config" env:"TERRAFILE_USER_CONFIG" description:"Configuration file of the user with mirror rules, terrafile/config.yaml in the user configuration folder by default"`
+
+	Retries int `long:"retries" default:"0" description:"Number of times fetching a module is retried after a failure"`
+
+	Root string `long:"root" default:"." description:"Project root, modules are never installed, linked or cleaned outside of it"`
+
+	AllowOutsideRoot bool `long:"allow-outside-root" description:"Allow module paths and destinations to point outside of the project root"`
+
+	GitProtocols []string `long:"git_protocol" default:"https" default:"ssh" default:"file" description:"Protocol git is allowed to fetch modules over, can be repeated"`
+
+	GitGlobalConfig bool `long:"git_global_config" description:"Let git use the global configuration of the user, e.g. for credentials or url rewrites"`
+
+	GitConfig []string `long:"git_config" description:"Extra git configuration in KEY=VALUE form passed to every git invocation, can be repeated"`
+
+	KeepGit bool `long:"keep_git" description:"Install modules as git clones including .git folder instead of exporting just their files"`
+
+	GitNoSandbox bool `long:"git_no_sandbox" description:"Run git with the configuration, hooks and protocols of the user\n !!! WARNING !!! Modules may run arbitrary commands while being fetched."`
+
+	ContentAction string `long:"content_action" default:"reject" choice:"strip" choice:"reject" description:"What to do with symlinks pointing outside of a module, special files, setuid/setgid bits, nested git repositories and files over size limit found in fetched modules"`
+
+	ContentExecutables string `long:"content_executables" default:"allow" choice:"allow" choice:"strip" choice:"reject" description:"What to do with executable files found in fetched modules, strip removes executable bits"`
+
+	ContentMaxFileSize int64 `long:"content_max_file_size" default:"52428800" description:"Maximum size of a single file in a fetched module in bytes, 0 disables the limit"`
+
+	ContentMaxSize int64 `long:"content_max_size" default:"524288000" description:"Maximum total size of a fetched module in bytes, 0 disables the limit"`
+
+	ContentMaxFiles int `long:"content_max_files" default:"10000" description:"Maximum number of files in a fetched module, 0 disables the limit"`
 }
 
+// parser of the command line, used to tell flags set explicitly from defaults
+var parser *flags.Parser
+
 // To be set by goreleaser on build
 var (
 	version = "dev"
@@ -55,73 +134,216 @@ func init() {
 	log.AddHook(stdemuxerhook.New(log.StandardLogger()))
 }
 
-func gitClone(repository string, version string, moduleName string, destinationDir string) {
-	cleanupPath := filepath.Join(destinationDir, moduleName)
-	log.Printf("[*] Removing previously cloned artifacts at %s", cleanupPath)
-	_ = os.RemoveAll(cleanupPath)
-	log.Printf("[*] Checking out %s of %s \n", version, repository)
-	cmd := exec.Command("git", "clone", "--single-branch", "--depth=1", "-b", version, repository, moduleName)
-	cmd.Dir = destinationDir
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("failed to clone repository %s due to error: %s", cmd.String(), err)
-	}
-}
-
 func main() {
 
 	fmt.Printf("Terrafile: version %v, commit %v, built at %v \n", version, commit, date)
-	_, err := flags.Parse(&opts)
 
-	// Invalid choice
-	if err != nil {
+	parser = newParser()
+	if _, err := parser.Parse(); err != nil {
+		var flagsErr *flags.Error
+		if !errors.As(err, &flagsErr) {
+			log.Errorf("%s", err)
+			os.Exit(1)
+		}
+		if flagsErr.Type == flags.ErrHelp {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+
+		// Invalid choice
 		log.Errorf("failed to parse flags due to: %s", err)
 		os.Exit(1)
 	}
+}
 
+// newParser returns the parser of the command line with every command
+func newParser() *flags.Parser {
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	parser.SubcommandsOptional = true
+	_, _ = parser.AddCommand("install", "Install modules of the Terrafile",
+		"Fetch every selected module of the Terrafile into its destinations and record the resolutions in the lockfile, same as running without a command. With --from-bundle, modules are installed from a bundle instead of fetched.",
+		&installCommand{})
+	_, _ = parser.AddCommand("validate", "Validate the Terrafile",
+		"Check the Terrafile for unknown fields, missing sources or versions, duplicate or invalid module names and bad destinations without fetching anything.",
+		&validateCommand{})
+	_, _ = parser.AddCommand("plan", "Show what would be installed",
+		"Show source, version and destinations of every selected module, with variables interpolated, without fetching anything.",
+		&planCommand{})
+	_, _ = parser.AddCommand("list", "List modules and where they are defined",
+		"Show source and version of every selected module together with the Terrafile it is defined in, including modules of included files.",
+		&listCommand{})
+	_, _ = parser.AddCommand("references", "Show which stacks use which vendored module",
+		"Scan terraform files for module blocks with a source in a module path and show the module, the stack and the file using it, and whether the module is installed there.",
+		&referencesCommand{})
+	doctor, _ := parser.AddCommand("doctor", "Diagnose the project",
+		"Commands finding problems in how the project uses vendored modules.",
+		&doctorCommand{})
+	_, _ = doctor.AddCommand("usage", "Report unused and missing modules",
+		"Scan terraform files of the project and report modules vendored into destinations where no module block references them, and module blocks referencing vendored modules the Terrafile doesn't provide.",
+		&doctorUsageCommand{})
+	_, _ = parser.AddCommand("import", "Generate a Terrafile from terraform files",
+		"Scan terraform files for module blocks with git or registry sources and generate a Terrafile vendoring them into the folders using them. With --rewrite, also show how module sources would point to the vendored modules. Nothing is written without --write.",
+		&importCommand{})
+	_, _ = parser.AddCommand("rewrite-sources", "Point module blocks to vendored modules",
+		"Rewrite the source of module blocks using a module of the Terrafile from its remote source to the vendored module, keeping the formatting of terraform files. With --to-remote, module blocks using vendored modules are pointed back to their remote source.",
+		&rewriteSourcesCommand{})
+	bundle, _ := parser.AddCommand("bundle", "Work with module bundles",
+		"Commands packaging modules for machines without access to their sources.",
+		&bundleCommand{})
+	_, _ = bundle.AddCommand("create", "Package installed modules into a bundle",
+		"Package every installed module of the Terrafile, and the modules they use, with their lockfile entries into a gzipped tarball. Bundles of the same modules are identical. Install from it with --from-bundle.",
+		&bundleCreateCommand{})
+	_, _ = parser.AddCommand("serve", "Serve vendored modules as a module registry",
+		"Serve every version of every module installed below the project through the terraform module registry protocol, so that terraform can fetch them without access to their repositories. Modules are named <owner>/<name>/<system> after their repository, following the terraform-<system>-<name> convention.",
+		&serveCommand{})
+	_, _ = parser.AddCommand("status", "Show state of installed modules",
+		"Show version and commit every module of the Terrafile is installed at and whether it was modified since, without fetching anything.",
+		&statusCommand{})
+	_, _ = parser.AddCommand("verify", "Verify installed modules",
+		"Fail unless every module of the Terrafile is installed at its version and unmodified, without fetching anything.",
+		&verifyCommand{})
+
+	// Running without a command installs modules, anything else left over is
+	// a mistyped command which must not install anything
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if command == nil && len(args) > 0 {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		if command == nil {
+			install("")
+			return nil
+		}
+		return command.Execute(args)
+	}
+
+	return parser
+}
+
+type installCommand struct {
+	FromBundle string `long:"from-bundle" description:"Install modules from a bundle written by 'bundle create' instead of fetching them, verifying them against the lockfile"`
+}
+
+// Execute installs modules of the Terrafile
+func (c *installCommand) Execute(_ []string) error {
+	install(c.FromBundle)
+	return nil
+}
+
+// install fetches all modules of the Terrafile into their destinations, or
+// installs them from the bundle named bundlePath if it is set
+func install(bundlePath string) {
 	workDirAbsolutePath, err := os.Getwd()
 	if err != nil {
 		log.Errorf("failed to get working directory absolute path due to: %s", err)
 	}
 
-	// Read File
-	yamlFile, err := os.ReadFile(opts.TerrafilePath)
+	// Read and parse File
+	allModules, err := loadTerrafile()
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	// Modules outside of selection are never touched
+	config, err := selectModules(allModules)
 	if err != nil {
-		log.Fatalf("failed to read configuration in file %s due to error: %s", opts.TerrafilePath, err)
+		log.Fatalf("%s", err)
+	}
+	unselected := unselectedModules(config, allModules)
+	warnOverrides(config)
+
+	// Refuse to touch anything outside of the project root
+	if errs := checkPaths(config); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		log.Fatalf("refusing to install modules outside of root %s, use --allow-outside-root to override", opts.Root)
+	}
+
+	// Refuse to pass anything git could interpret as an option or a command
+	if errs := checkSources(config); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		log.Fatalf("refusing to fetch modules from disallowed sources")
+	}
+
+	// Install nothing but what the lockfile pins
+	var bundle *moduleBundle
+	if bundlePath != "" {
+		if overrides := activeOverrides(config); len(overrides) > 0 {
+			log.Fatalf("refusing to install modules from bundle %s while overrides are active", bundlePath)
+		}
+		if bundle, err = openBundle(bundlePath); err != nil {
+			log.Fatalf("%s", err)
+		}
+		defer bundle.close()
 	}
 
-	// Parse File
-	var config map[string]module
-	if err := yaml.Unmarshal(yamlFile, &config); err != nil {
-		log.Fatalf("failed to parse yaml file due to error: %s", err)
+	// Refuse to throw away local edits of vendored modules
+	if err := protectModules(config, unselected); err != nil {
+		log.Fatalf("%s", err)
 	}
 
 	if opts.Clean {
-		cleanDestinations(config)
+		cleanDestinations(config, unselected)
 	}
 
 	// Clone modules
-	var wg sync.WaitGroup
-	_ = os.RemoveAll(opts.ModulePath)
+	pruneModulePath(allModules)
 	_ = os.MkdirAll(opts.ModulePath, os.ModePerm)
 
+	installModules(config, workDirAbsolutePath, bundle)
+
+	// Vendor remote modules the vendored modules use
+	if opts.Transitive {
+		derived, err := installDependencies(config, allModules, func(modules map[string]module) {
+			installModules(modules, workDirAbsolutePath, bundle)
+		})
+		if err != nil {
+			log.Fatalf("failed to vendor dependencies of modules due to error: %s", err)
+		}
+		for key, m := range derived {
+			config[key] = m
+			allModules[key] = m
+		}
+		log.Infof("[*] Vendored %d module(s) used by vendored modules", len(derived))
+	}
+
+	// Point terraform at vendored modules for remote sources
+	if opts.ModuleManifest {
+		if err := writeManifests(config); err != nil {
+			log.Fatalf("failed to write module manifests due to error: %s", err)
+		}
+	}
+
+	if err := recordLock(allModules, config); err != nil {
+		log.Fatalf("failed to update %s due to error: %s", lockPath(opts.TerrafilePath), err)
+	}
+	log.Infof("[*] Recorded resolutions of profile %s in %s", activeProfile(), lockPath(opts.TerrafilePath))
+}
+
+// installModules fetches modules of config into their destinations, or links
+// their local checkouts, and links them to their other destinations. Modules
+// are installed from bundle instead of fetched if it is set.
+func installModules(config map[string]module, workDirAbsolutePath string, bundle *moduleBundle) {
+	// limits number of modules fetched at the same time
+	var slots chan struct{}
+	if opts.Concurrency > 0 {
+		slots = make(chan struct{}, opts.Concurrency)
+	}
+
+	var wg sync.WaitGroup
 	for key, mod := range config {
 		wg.Add(1)
 		go func(m module, key string) {
 			defer wg.Done()
-
-			// path to clone module
-			cloneDestination := opts.ModulePath
-			// list of paths to link module to. empty, unless Destinations are more than 1 location
-			var linkDestinations []string
-
-			if m.Destinations != nil && len(m.Destinations) > 0 {
-				// set first in Destinations as location to clone to
-				cloneDestination = filepath.Join(m.Destinations[0], opts.ModulePath)
-				// the rest of Destinations are locations to link module to
-				linkDestinations = m.Destinations[1:]
-
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
 			}
 
+			cloneDestination, linkDestinations := moduleDestinations(m)
+
 			// create folder to clone into
 			if err := os.MkdirAll(cloneDestination, os.ModePerm); err != nil {
 				log.Errorf("failed to create folder %s due to error: %s", cloneDestination, err)
@@ -130,8 +352,17 @@ func main() {
 				return
 			}
 
-			// clone repository
-			gitClone(m.Source, m.Version, key, cloneDestination)
+			// fetch module, or link its local checkout
+			install, link := installModuleWithRetries, linkModule
+			if m.LocalPath != "" {
+				// local checkouts are always symlinked so that edits show up immediately
+				install, link = linkLocalModule, os.Symlink
+			} else if bundle != nil {
+				install = bundle.installModule
+			}
+			if err := install(key, m, cloneDestination); err != nil {
+				log.Fatalf("failed to install module %s due to error: %s", key, err)
+			}
 
 			for _, d := range linkDestinations {
 				// the source location as folder where module was cloned and module folder name
@@ -154,7 +385,7 @@ func main() {
 				}
 
 				log.Infof("[*] Link %s to %s", moduleSrc, dst)
-				if err := os.Symlink(moduleSrc, dst); err != nil {
+				if err := link(moduleSrc, dst); err != nil {
 					log.Errorf("failed to link module from %s to %s due to error: %s", moduleSrc, dst, err)
 				}
 			}
@@ -164,7 +395,61 @@ func main() {
 	wg.Wait()
 }
 
-func cleanDestinations(config map[string]module) {
+// flagSet reports whether the option with long name was given on the command line
+func flagSet(name string) bool {
+	if parser == nil {
+		return false
+	}
+	option := parser.FindOptionByLongName(name)
+	return option != nil && option.IsSet() && !option.IsSetDefault()
+}
+
+// moduleDestinations returns the folder to clone the module into and the list
+// of destinations the cloned module should be linked to
+func moduleDestinations(m module) (cloneDestination string, linkDestinations []string) {
+	// path to clone module
+	cloneDestination = opts.ModulePath
+
+	if len(m.Destinations) > 0 {
+		// set first in Destinations as location to clone to
+		cloneDestination = filepath.Join(m.Destinations[0], opts.ModulePath)
+		// the rest of Destinations are locations to link module to
+		linkDestinations = m.Destinations[1:]
+	}
+
+	return cloneDestination, linkDestinations
+}
+
+// cleanDestinations removes everything from destinations of config, except
+// for unselected modules
+func cleanDestinations(config map[string]module, unselected map[string]bool) {
+	for dst := range uniqueDestinations(config) {
+
+		log.Infof("[*] Removing artifacts from %s", dst)
+		if len(unselected) == 0 {
+			if err := os.RemoveAll(dst); err != nil {
+				log.Errorf("Failed to remove artifacts from %s due to error: %s", dst, err)
+			}
+			continue
+		}
+
+		entries, err := os.ReadDir(dst)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if unselected[entry.Name()] {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(dst, entry.Name())); err != nil {
+				log.Errorf("Failed to remove artifacts from %s due to error: %s", dst, err)
+			}
+		}
+	}
+}
+
+// uniqueDestinations returns every module path the config installs modules into
+func uniqueDestinations(config map[string]module) map[string]bool {
 
 	// Map filters duplicate destinations with key being each destination's file path
 	uniqueDestinations := make(map[string]bool)
@@ -184,11 +469,5 @@ func cleanDestinations(config map[string]module) {
 		}
 	}
 
-	for dst := range uniqueDestinations {
-
-		log.Infof("[*] Removing artifacts from %s", dst)
-		if err := os.RemoveAll(dst); err != nil {
-			log.Errorf("Failed to remove artifacts from %s due to error: %s", dst, err)
-		}
-	}
+	return uniqueDestinations
 }
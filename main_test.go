@@ -134,7 +134,7 @@ func TestTerraformWithTerrafilePath(t *testing.T) {
 		if !testcli.Success() {
 			t.Fatalf("Expected to succeed, but failed: %q with message: %q", testcli.Error(), testcli.Stderr())
 		}
-		testcli.Run("diff", "--exclude=.git", "-r", path.Join(workingDirectory, "vendor/modules", moduleName), testModuleLocation)
+		testcli.Run("diff", "--exclude=.git", "--exclude=.terrafile.json", "-r", path.Join(workingDirectory, "vendor/modules", moduleName), testModuleLocation)
 		if !testcli.Success() {
 			t.Fatalf("File difference found for %q, with failure: %q with message: %q", moduleName, testcli.Error(), testcli.Stderr())
 		}
@@ -178,7 +178,7 @@ func TestTerraformWithTerrafilePath(t *testing.T) {
 			if !testcli.Success() {
 				t.Fatalf("Expected to succeed, but failed: %q with message: %q", testcli.Error(), testcli.Stderr())
 			}
-			testcli.Run("diff", "--exclude=.git", "-r", path.Join(workingDirectory, dst, moduleName), testModuleLocation)
+			testcli.Run("diff", "--exclude=.git", "--exclude=.terrafile.json", "-r", path.Join(workingDirectory, dst, moduleName), testModuleLocation)
 			if !testcli.Success() {
 				t.Fatalf("File difference found for %q, with failure: %q with message: %q", moduleName, testcli.Error(), testcli.Stderr())
 			}
@@ -261,6 +261,16 @@ func TestTerraformWithTerrafileClean(t *testing.T) {
 	}
 }
 
+func TestUnknownCommand(t *testing.T) {
+	defer restoreOpts()()
+
+	// would install modules and prune the module path if the command was ignored
+	for _, args := range [][]string{{"validat"}, {"bogus", "--module_path", "modules"}} {
+		_, err := newParser().ParseArgs(args)
+		assert.EqualError(t, err, fmt.Sprintf("unknown command %q", args[0]))
+	}
+}
+
 func setup(t *testing.T) (current string, back func()) {
 	folder, err := os.MkdirTemp("", "")
 	assert.NoError(t, err)
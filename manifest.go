@@ -0,0 +1,289 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxManifestDepth limits how deep module calls are followed
+const maxManifestDepth = 32
+
+// scpPattern matches git sources in scp-like syntax, user@host:path
+var scpPattern = regexp.MustCompile(`^[^/:@]+@[^/:]+:`)
+
+// manifestFile is where terraform records installed modules, relative to a stack
+var manifestFile = filepath.Join(".terraform", "modules", "modules.json")
+
+// moduleManifest is the module manifest terraform init and get maintain
+type moduleManifest struct {
+	Modules []manifestRecord `json:"Modules"`
+}
+
+// manifestRecord records the folder a module call of a stack is installed in.
+// Key is the path of the call like `vpc.subnets`, Dir is relative to the stack.
+type manifestRecord struct {
+	Key     string `json:"Key"`
+	Source  string `json:"Source"`
+	Version string `json:"Version,omitempty"`
+	Dir     string `json:"Dir"`
+}
+
+// writeManifests writes the module manifest of every destination of config,
+// pointing module calls with remote sources of installed modules at them
+func writeManifests(config map[string]module) error {
+	stacks := make(map[string]bool)
+	for _, m := range config {
+		for _, d := range destinationsOf(m) {
+			stacks[filepath.Clean(d)] = true
+		}
+	}
+	sorted := make([]string, 0, len(stacks))
+	for stack := range stacks {
+		sorted = append(sorted, stack)
+	}
+	sort.Strings(sorted)
+
+	for _, stack := range sorted {
+		records, err := manifestRecords(stack, config)
+		if err != nil {
+			return fmt.Errorf("failed to find module calls of %s due to error: %s", stack, err)
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		filename := filepath.Join(stack, manifestFile)
+		if err := updateManifest(filename, records); err != nil {
+			return fmt.Errorf("failed to update %s due to error: %s", filename, err)
+		}
+		log.Infof("[*] Recorded %d vendored module call(s) in %s", len(records), filename)
+	}
+
+	return nil
+}
+
+// manifestRecords returns records of the module calls of stack which use
+// modules of config installed for it, and of every call below them
+func manifestRecords(stack string, config map[string]module) ([]manifestRecord, error) {
+	var records []manifestRecord
+	vendored := false
+
+	var walk func(prefix string, dir string, depth int) error
+	walk = func(prefix string, dir string, depth int) error {
+		if depth > maxManifestDepth {
+			return fmt.Errorf("module calls are nested more than %d levels deep at %s", maxManifestDepth, prefix)
+		}
+		blocks, err := folderModuleBlocks(filepath.Join(stack, filepath.FromSlash(dir)))
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			key := block.Name
+			if prefix != "" {
+				key = prefix + "." + block.Name
+			}
+
+			record := manifestRecord{Key: key, Source: terraformSourceAddr(block.Source)}
+			if strings.HasPrefix(block.Source, "./") || strings.HasPrefix(block.Source, "../") {
+				record.Dir = path.Join(dir, block.Source)
+			} else {
+				remote, err := parseModuleSource(block.Source, block.Version)
+				if err != nil {
+					continue
+				}
+				dir, ok := installedFor(config, remote, stack)
+				if !ok {
+					// terraform fetches it
+					continue
+				}
+				record.Dir = dir
+				if registrySource(block.Source) {
+					record.Version = strings.TrimPrefix(remote.Version, "v")
+				}
+				vendored = true
+			}
+
+			records = append(records, record)
+			if err := walk(key, record.Dir, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", ".", 0); err != nil {
+		return nil, err
+	}
+	if !vendored {
+		return nil, nil
+	}
+
+	return append([]manifestRecord{{Key: "", Source: "", Dir: "."}}, records...), nil
+}
+
+// terraformSourceAddr returns source of a module block the way terraform
+// records it in the manifest: registry modules with the host of the public
+// registry, shorthands of git repositories as the git URL they stand for and
+// the subdirectory in front of the query, e.g. github.com/org/repo//sub?ref=v1
+// as git::https://github.com/org/repo.git//sub?ref=v1
+func terraformSourceAddr(source string) string {
+	if strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") {
+		return source
+	}
+	if registrySource(source) {
+		return "registry.terraform.io/" + source
+	}
+
+	rest := strings.TrimPrefix(source, "git::")
+	rest, query, _ := strings.Cut(rest, "?")
+	var subdir string
+	schemeEnd := 0
+	if i := strings.Index(rest, "://"); i >= 0 {
+		schemeEnd = i + 3
+	}
+	if i := strings.Index(rest[schemeEnd:], "//"); i >= 0 {
+		rest, subdir = rest[:schemeEnd+i], rest[schemeEnd+i+2:]
+	}
+
+	switch {
+	case strings.HasPrefix(rest, "github.com/"):
+		// folders after owner and repository are a subdirectory too
+		parts := strings.SplitN(rest, "/", 4)
+		if len(parts) < 3 {
+			return source
+		}
+		if len(parts) == 4 {
+			subdir = strings.Trim(path.Join(parts[3], subdir), "/")
+		}
+		rest = "https://" + strings.Join(parts[:3], "/")
+		if !strings.HasSuffix(rest, ".git") {
+			rest += ".git"
+		}
+	case schemeEnd == 0 && scpPattern.MatchString(rest):
+		host, repository, _ := strings.Cut(rest, ":")
+		rest = "ssh://" + host + "/" + strings.TrimPrefix(repository, "/")
+	case !strings.HasPrefix(source, "git::"):
+		return source
+	}
+
+	addr := "git::" + rest
+	if subdir != "" {
+		addr += "//" + subdir
+	}
+	if query != "" {
+		addr += "?" + query
+	}
+	return addr
+}
+
+// installedFor returns the folder, relative to stack, the module of config
+// with source remote is installed into for stack
+func installedFor(config map[string]module, remote remoteSource, stack string) (string, bool) {
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		if catalogKey(m.Source) != catalogKey(remote.Source) || m.Version != remote.Version {
+			continue
+		}
+		if dir, ok := vendoredPath(key, m, stack, remote.Subdir); ok {
+			return strings.TrimPrefix(dir, "./"), true
+		}
+	}
+	return "", false
+}
+
+// folderModuleBlocks returns module blocks of the terraform files in dir,
+// ordered by file name. A missing folder has none.
+func folderModuleBlocks(dir string) ([]moduleBlock, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []moduleBlock
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".tf" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, scanModuleBlocks(string(data))...)
+	}
+	return blocks, nil
+}
+
+// updateManifest replaces records of the manifest named filename with
+// records, together with every record below them. Other records, like those of
+// modules terraform fetched itself, are kept.
+func updateManifest(filename string, records []manifestRecord) error {
+	var manifest moduleManifest
+	data, err := os.ReadFile(filename)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	replaced := make(map[string]bool)
+	for _, r := range records {
+		replaced[r.Key] = true
+	}
+	kept := records
+	for _, r := range manifest.Modules {
+		if !replaced[r.Key] && !replacedParent(r.Key, replaced) {
+			kept = append(kept, r)
+		}
+	}
+	// terraform writes records ordered by key
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Key < kept[j].Key })
+
+	data, err = json.Marshal(moduleManifest{Modules: kept})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// replacedParent reports whether any module call key is below is replaced
+func replacedParent(key string, replaced map[string]bool) bool {
+	for i := strings.LastIndex(key, "."); i > 0; i = strings.LastIndex(key[:i], ".") {
+		if replaced[key[:i]] {
+			return true
+		}
+	}
+	return false
+}
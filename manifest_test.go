@@ -0,0 +1,133 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteManifests(t *testing.T) {
+	// recorded by `terraform init` of terraform 1.5.7 in stacks/app below, with
+	// the git repositories and the registry module served from local copies
+	fixture, err := os.ReadFile("fixtures/modules.json")
+	assert.NoError(t, err)
+	var recorded moduleManifest
+	assert.NoError(t, json.Unmarshal(fixture, &recorded))
+
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{
+		"stacks/app/modules/local",
+		"stacks/app/vendor/modules/tf-aws-vpc/modules/subnets",
+		"stacks/app/vendor/modules/terraform-aws-iam/modules/iam-role",
+		"stacks/app/.terraform/modules",
+		"stacks/db",
+	} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+	createFile(t, "stacks/app/main.tf", `module "vpc" {
+  source = "git::https://github.com/org/tf-aws-vpc.git?ref=v1.0.0"
+}
+
+module "iam" {
+  source  = "terraform-aws-modules/iam/aws//modules/iam-role"
+  version = "5.1.0"
+}
+
+module "unknown" {
+  source = "git::https://github.com/org/other.git?ref=v1.0.0"
+}
+`)
+	createFile(t, "stacks/app/local.tf", `module "local" {
+  source = "./modules/local"
+}
+`)
+	createFile(t, "stacks/app/modules/local/main.tf", `module "label" {
+  source = "github.com/org/tf-aws-vpc?ref=v1.0.0"
+}
+`)
+	createFile(t, "stacks/app/vendor/modules/tf-aws-vpc/main.tf", `module "subnets" {
+  source = "./modules/subnets"
+}
+`)
+	createFile(t, "stacks/app/vendor/modules/tf-aws-vpc/modules/subnets/main.tf", "# subnets\n")
+	createFile(t, "stacks/app/vendor/modules/terraform-aws-iam/modules/iam-role/main.tf", "# role\n")
+	// terraform fetched unknown, vpc is replaced with the vendored module
+	createFile(t, "stacks/app/.terraform/modules/modules.json", `{"Modules":[{"Key":"","Source":"","Dir":"."},{"Key":"unknown","Source":"git::https://github.com/org/other.git?ref=v1.0.0","Dir":".terraform/modules/unknown"},{"Key":"vpc","Source":"git::https://github.com/org/tf-aws-vpc.git?ref=v0.9.0","Dir":".terraform/modules/vpc"},{"Key":"vpc.old","Source":"./modules/old","Dir":".terraform/modules/vpc/modules/old"}]}`)
+	createFile(t, "stacks/db/main.tf", `module "other" {
+  source = "git::https://github.com/org/other.git?ref=v1.0.0"
+}
+`)
+
+	config := map[string]module{
+		"tf-aws-vpc":        {Source: "https://github.com/org/tf-aws-vpc.git", Version: "v1.0.0", Destinations: []string{"stacks/app", "stacks/db"}},
+		"terraform-aws-iam": {Source: "https://github.com/terraform-aws-modules/terraform-aws-iam.git", Version: "v5.1.0", Destinations: []string{"stacks/app"}},
+	}
+	assert.NoError(t, writeManifests(config))
+
+	// calls of installed modules are recorded the way terraform does, just in
+	// the folders the modules were installed into
+	vendored := map[string]string{
+		"iam":                 "vendor/modules/terraform-aws-iam/modules/iam-role",
+		"local.label":         "vendor/modules/tf-aws-vpc",
+		"local.label.subnets": "vendor/modules/tf-aws-vpc/modules/subnets",
+		"vpc":                 "vendor/modules/tf-aws-vpc",
+		"vpc.subnets":         "vendor/modules/tf-aws-vpc/modules/subnets",
+	}
+	for i, record := range recorded.Modules {
+		if dir, ok := vendored[record.Key]; ok {
+			recorded.Modules[i].Dir = dir
+		}
+	}
+	data, err := os.ReadFile(filepath.Join("stacks/app", manifestFile))
+	assert.NoError(t, err)
+	var manifest moduleManifest
+	assert.NoError(t, json.Unmarshal(data, &manifest))
+	assert.Equal(t, recorded, manifest)
+
+	// stacks not using any vendored module are left alone
+	assert.NoFileExists(t, filepath.Join("stacks/db", manifestFile))
+}
+
+func TestTerraformSourceAddr(t *testing.T) {
+	// the local, registry, github.com and git@ sources are as terraform 1.5.7 records them
+	for source, expected := range map[string]string{
+		"./modules/local":                                 "./modules/local",
+		"terraform-aws-modules/vpc/aws":                   "registry.terraform.io/terraform-aws-modules/vpc/aws",
+		"terraform-aws-modules/iam/aws//modules/iam-role": "registry.terraform.io/terraform-aws-modules/iam/aws//modules/iam-role",
+		"app.terraform.io/org/vpc/aws":                    "app.terraform.io/org/vpc/aws",
+		"github.com/org/repo":                             "git::https://github.com/org/repo.git",
+		"github.com/org/repo.git?ref=v1.0.0":              "git::https://github.com/org/repo.git?ref=v1.0.0",
+		"github.com/org/repo//modules/sub?ref=v1.0.0":     "git::https://github.com/org/repo.git//modules/sub?ref=v1.0.0",
+		"github.com/org/repo/modules/sub":                 "git::https://github.com/org/repo.git//modules/sub",
+		"git@github.com:org/repo.git?ref=v1.0.0":          "git::ssh://git@github.com/org/repo.git?ref=v1.0.0",
+		"git::git@github.com:org/repo.git//sub?ref=v1":    "git::ssh://git@github.com/org/repo.git//sub?ref=v1",
+		"git::https://example.com/repo.git//sub?ref=v1":   "git::https://example.com/repo.git//sub?ref=v1",
+		"git::ssh://git@example.com/repo.git?ref=v1":      "git::ssh://git@example.com/repo.git?ref=v1",
+		"https://example.com/module.zip":                  "https://example.com/module.zip",
+	} {
+		assert.Equal(t, expected, terraformSourceAddr(source), source)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// metadataFile is the name of the file terrafile records installed module in,
+// it's placed in the root folder of every installed module
+const metadataFile = ".terrafile.json"
+
+// moduleMetadata describes what was installed into a module folder
+type moduleMetadata struct {
+	Source  string `json:"source"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	// Filters lists include and exclude patterns applied to the module
+	Filters []string `json:"filters,omitempty"`
+	// Hash covers all Files, see hashModule
+	Hash string `json:"hash"`
+	// Files maps slash separated path of every file to hash of its content
+	Files map[string]string `json:"files"`
+	// Nested is set if modules of a Terrafile shipped by the module were installed
+	Nested bool `json:"nested,omitempty"`
+	// Transitive is set if module sources were pointed to vendored modules
+	Transitive bool `json:"transitive,omitempty"`
+	// Dependencies are the remote modules the module uses, vendored next to it
+	Dependencies []moduleDependency `json:"dependencies,omitempty"`
+}
+
+// readMetadata reads metadata of module installed in dir, it returns nil
+// metadata without error if the module wasn't installed by terrafile
+func readMetadata(dir string) (*moduleMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata moduleMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s due to error: %s", filepath.Join(dir, metadataFile), err)
+	}
+
+	return &metadata, nil
+}
+
+// writeMetadata writes metadata of module installed in dir
+func writeMetadata(dir string, metadata moduleMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, metadataFile), append(data, '\n'), 0644)
+}
+
+// hashModule hashes every file of module in dir except for git and terrafile
+// metadata. It returns hash of each file and a hash over all of them.
+func hashModule(dir string) (files map[string]string, hash string, err error) {
+	files = make(map[string]string)
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case rel == ".git" && entry.IsDir():
+			return filepath.SkipDir
+		case rel == "." || rel == metadataFile || entry.IsDir():
+			return nil
+		}
+
+		fileHash, err := hashFile(path, entry)
+		if err != nil {
+			return err
+		}
+		files[rel] = fileHash
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return files, combineHashes(files), nil
+}
+
+// hashFile hashes content of a regular file or target of a symlink
+func hashFile(path string, entry fs.DirEntry) (string, error) {
+	h := sha256.New()
+
+	if entry.Type()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		_, _ = io.WriteString(h, "symlink:"+filepath.ToSlash(target))
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// combineHashes returns a single hash over paths and hashes of all files
+func combineHashes(files map[string]string) string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		_, _ = fmt.Fprintf(h, "%s %s\n", files[path], path)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// diffFiles compares recorded hashes of files with the current ones and lists
+// the differences in `git status --porcelain` format
+func diffFiles(recorded map[string]string, current map[string]string) []string {
+	var changes []string
+
+	for path, hash := range current {
+		switch recordedHash, ok := recorded[path]; {
+		case !ok:
+			changes = append(changes, "?? "+path)
+		case recordedHash != hash:
+			changes = append(changes, " M "+path)
+		}
+	}
+	for path := range recorded {
+		if _, ok := current[path]; !ok {
+			changes = append(changes, " D "+path)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i][3:] < changes[j][3:]
+	})
+
+	return changes
+}
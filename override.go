@@ -0,0 +1,252 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// overrideSuffixes are appended to the name of the Terrafile to get names of
+// the local, git-ignored, file merged over it
+var overrideSuffixes = []string{".override", ".local"}
+
+// overrideFields lists every field an override may have
+var overrideFields = []string{"source", "version", "path"}
+
+// moduleOverride redirects a module to another source or version, or to a
+// local checkout at path
+type moduleOverride struct {
+	Source  string `yaml:"source"`
+	Version string `yaml:"version"`
+	Path    string `yaml:"path"`
+}
+
+// overrideFile returns name of the override file of the Terrafile at
+// terrafilePath, or an empty string if there is none
+func overrideFile(terrafilePath string) (string, error) {
+	var found []string
+	for _, suffix := range overrideSuffixes {
+		if _, err := os.Stat(terrafilePath + suffix); err == nil {
+			found = append(found, terrafilePath+suffix)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("found both %s, keep only one of them", strings.Join(found, " and "))
+	}
+}
+
+// loadOverrides merges the override file of the Terrafile at terrafilePath,
+// if there is one, over modules of config
+func loadOverrides(terrafilePath string, config map[string]module) []error {
+	filename, err := overrideFile(terrafilePath)
+	if err != nil {
+		return []error{err}
+	}
+	if filename == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return []error{fmt.Errorf("failed to read overrides in file %s due to error: %s", filename, err)}
+	}
+	overrides, errs := parseOverrides(filename, data)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return applyOverrides(config, filename, overrides)
+}
+
+// parseOverrides strictly decodes contents of the override file named filename
+func parseOverrides(filename string, data []byte) (map[string]moduleOverride, []error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, []error{validationError{File: filename, Message: err.Error()}}
+	}
+
+	overrides := make(map[string]moduleOverride)
+	if len(root.Content) == 0 {
+		return overrides, nil
+	}
+
+	v := validator{file: filename}
+	v.interpolateNode(&root)
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	node := root.Content[0]
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping of module names to overrides")
+		return nil, v.errs
+	}
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if line, ok := seen[keyNode.Value]; ok {
+			v.errorf(keyNode, "duplicate override of module %q, first defined on line %d", keyNode.Value, line)
+			continue
+		}
+		seen[keyNode.Value] = keyNode.Line
+		v.validateOverride(keyNode.Value, valueNode)
+	}
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	if err := node.Decode(&overrides); err != nil {
+		return nil, []error{validationError{File: filename, Message: err.Error()}}
+	}
+
+	return overrides, nil
+}
+
+func (v *validator) validateOverride(key string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "override of module %q must be a mapping with %s fields", key, strings.Join(overrideFields, ", "))
+		return
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fieldNode, valueNode := node.Content[i], node.Content[i+1]
+		field := fieldNode.Value
+
+		if _, ok := fields[field]; ok {
+			v.errorf(fieldNode, "duplicate field %q in override of module %q", field, key)
+			continue
+		}
+		fields[field] = valueNode
+
+		if !contains(overrideFields, field) {
+			message := fmt.Sprintf("unknown field %q in override of module %q", field, key)
+			if suggestion := closest(field, overrideFields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.errorf(fieldNode, "%s", message)
+			continue
+		}
+		if valueNode.Kind != yaml.ScalarNode || strings.TrimSpace(valueNode.Value) == "" {
+			v.errorf(valueNode, "field %q of override of module %q must be a non-empty string", field, key)
+		}
+	}
+
+	_, hasPath := fields["path"]
+	switch {
+	case len(fields) == 0:
+		v.errorf(node, "override of module %q must set a source, a version or a path", key)
+	case hasPath && len(fields) > 1:
+		v.errorf(node, "override of module %q must set either a path or a source and version, not both", key)
+	}
+}
+
+// applyOverrides redirects modules of config as set in the override file
+// named filename. Local paths are relative to the override file.
+func applyOverrides(config map[string]module, filename string, overrides map[string]moduleOverride) []error {
+	var errs []error
+	for key, override := range overrides {
+		m, ok := config[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: override of unknown module %q", filename, key))
+			continue
+		}
+
+		if override.Path != "" {
+			path, err := filepath.Abs(filepath.Join(filepath.Dir(filename), override.Path))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			m.LocalPath = path
+		}
+		if override.Source != "" {
+			m.Source = override.Source
+		}
+		if override.Version != "" {
+			m.Version = override.Version
+		}
+		m.Origin = filename
+		m.Overridden = true
+		config[key] = m
+	}
+
+	return errs
+}
+
+// activeOverrides describes every overridden module of config, ordered by module name
+func activeOverrides(config map[string]module) []string {
+	var overrides []string
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		switch {
+		case !m.Overridden:
+		case m.LocalPath != "":
+			overrides = append(overrides, fmt.Sprintf("%s: linked to local checkout %s", key, m.LocalPath))
+		default:
+			overrides = append(overrides, fmt.Sprintf("%s: %s of %s", key, m.Version, m.Source))
+		}
+	}
+	return overrides
+}
+
+// warnOverrides loudly lists every overridden module of config
+func warnOverrides(config map[string]module) {
+	overrides := activeOverrides(config)
+	if len(overrides) == 0 {
+		return
+	}
+
+	log.Warnf("[!] !!! WARNING !!! %d module(s) are overridden and NOT installed as defined in %s:", len(overrides), opts.TerrafilePath)
+	for _, override := range overrides {
+		log.Warnf("[!]     %s", override)
+	}
+	log.Warnf("[!] Remove the override file before committing, `terrafile verify` fails while it is active")
+}
+
+// linkLocalModule links destinationDir/key to the local checkout of module m,
+// so that edits of the checkout show up immediately
+func linkLocalModule(key string, m module, destinationDir string) error {
+	info, err := os.Stat(m.LocalPath)
+	if err != nil {
+		return fmt.Errorf("local checkout of module %s is not available due to error: %s", key, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("local checkout %s of module %s is not a folder", m.LocalPath, key)
+	}
+
+	moduleDir := filepath.Join(destinationDir, key)
+	log.Infof("[*] Removing previously cloned artifacts at %s", moduleDir)
+	if err := os.RemoveAll(moduleDir); err != nil {
+		return err
+	}
+
+	log.Infof("[*] Link local checkout %s to %s", m.LocalPath, moduleDir)
+	return os.Symlink(m.LocalPath, moduleDir)
+}
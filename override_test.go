@@ -0,0 +1,104 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOverrides(t *testing.T) {
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	config := map[string]module{
+		"tf-aws-vpc": {Source: "git@github.com:terraform-aws-modules/terraform-aws-vpc", Version: "v1.46.0", Origin: "Terrafile"},
+		"tf-aws-iam": {Source: "git@github.com:terraform-aws-modules/terraform-aws-iam", Version: "v1.0.0", Origin: "Terrafile"},
+		"tf-aws-s3":  {Source: "git@github.com:terraform-aws-modules/terraform-aws-s3-bucket", Version: "v2.0.0", Origin: "Terrafile"},
+	}
+
+	// no override file, nothing changes
+	assert.Empty(t, loadOverrides("Terrafile", config))
+	assert.Empty(t, activeOverrides(config))
+
+	createFile(t, "Terrafile.override", `tf-aws-vpc:
+  path: ../terraform-aws-vpc
+tf-aws-iam:
+  source:  "git@github.com:me/terraform-aws-iam"
+  version: "fix-policy"
+`)
+	assert.Empty(t, loadOverrides("Terrafile", config))
+
+	local, err := filepath.Abs("../terraform-aws-vpc")
+	assert.NoError(t, err)
+	assert.Equal(t, local, config["tf-aws-vpc"].LocalPath)
+	assert.Equal(t, "Terrafile.override", config["tf-aws-vpc"].Origin)
+	assert.Equal(t, []string{
+		"tf-aws-iam: fix-policy of git@github.com:me/terraform-aws-iam",
+		"tf-aws-vpc: linked to local checkout " + local,
+	}, activeOverrides(config))
+	assert.False(t, config["tf-aws-s3"].Overridden)
+
+	createFile(t, "Terrafile.local", "")
+	assert.EqualError(t, loadOverrides("Terrafile", config)[0], "found both Terrafile.override and Terrafile.local, keep only one of them")
+	assert.NoError(t, os.Remove("Terrafile.override"))
+
+	createFile(t, "Terrafile.local", "tf-aws-vcp:\n  version: main\n")
+	assert.EqualError(t, loadOverrides("Terrafile", config)[0], `Terrafile.local: override of unknown module "tf-aws-vcp"`)
+}
+
+func TestParseOverridesErrors(t *testing.T) {
+	_, errs := parseOverrides("Terrafile.override", []byte(`tf-aws-vpc:
+  path:    ../terraform-aws-vpc
+  version: main
+tf-aws-iam:
+  srouce: "git@github.com:me/terraform-aws-iam"
+`))
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		`Terrafile.override:2:3: override of module "tf-aws-vpc" must set either a path or a source and version, not both`,
+		`Terrafile.override:5:3: unknown field "srouce" in override of module "tf-aws-iam", did you mean "source"?`,
+	}, messages)
+}
+
+func TestLocalModuleStatus(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	local, err := filepath.Abs("terraform-aws-vpc")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(local, os.ModePerm))
+	config := map[string]module{
+		"tf-aws-vpc": {Source: "unused", Version: "v1.0.0", Destinations: []string{"networking", "onboarding"}, Overridden: true, LocalPath: local},
+	}
+
+	assert.NoError(t, os.MkdirAll("networking/vendor/modules", os.ModePerm))
+	assert.NoError(t, linkLocalModule("tf-aws-vpc", config["tf-aws-vpc"], "networking/vendor/modules"))
+	createFile(t, "terraform-aws-vpc/main.tf", "# work in progress\n")
+	assert.FileExists(t, "networking/vendor/modules/tf-aws-vpc/main.tf")
+
+	statuses := moduleStatuses(config)
+	assert.Equal(t, []string{stateOverridden, stateMissing}, states(statuses))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkPaths makes sure every path the config installs, links or cleans
+// modules at stays inside of the project root
+func checkPaths(config map[string]module) []error {
+	if opts.AllowOutsideRoot {
+		return nil
+	}
+
+	root, err := filepath.Abs(opts.Root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return []error{fmt.Errorf("failed to resolve root %s due to error: %s", opts.Root, err)}
+	}
+
+	var errs []error
+	checked := make(map[string]bool)
+	check := func(owner string, path string) {
+		if checked[path] {
+			return
+		}
+		checked[path] = true
+		if err := checkInsideRoot(root, path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", owner, err))
+		}
+	}
+
+	check("module path", opts.ModulePath)
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		owner := fmt.Sprintf("module %q", key)
+		// the key must name a folder directly inside of the module path
+		if key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+			errs = append(errs, fmt.Errorf("%s: module name must be a single folder name", owner))
+			continue
+		}
+		cloneDestination, linkDestinations := moduleDestinations(config[key])
+		check(owner, cloneDestination)
+		check(owner, filepath.Join(cloneDestination, key))
+		for _, d := range linkDestinations {
+			check(owner, filepath.Join(d, opts.ModulePath))
+			check(owner, filepath.Join(d, opts.ModulePath, key))
+		}
+	}
+
+	return errs
+}
+
+// checkInsideRoot returns an error unless path, once symlinks are resolved, is
+// located strictly inside of the already resolved root
+func checkInsideRoot(root string, path string) error {
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %s due to error: %s", path, err)
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return fmt.Errorf("path %s resolves to %s which is outside of root %s", path, resolved, root)
+	}
+	if rel == "." {
+		return fmt.Errorf("path %s resolves to the root %s itself", path, root)
+	}
+
+	return nil
+}
+
+// resolvePath returns the absolute form of path with symlinks resolved in
+// every existing parent folder. The last element is kept as is, because
+// terrafile removes or replaces it rather than following it.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	parent, base := filepath.Dir(abs), filepath.Base(abs)
+	if parent == abs {
+		// file system root
+		return abs, nil
+	}
+
+	// walk up to the closest parent that exists, symlinks can only hide there
+	existing, rest := parent, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		next := filepath.Dir(existing)
+		if next == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = next
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(resolved, rest, base), nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPaths(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	defer restoreOpts()()
+	opts.Root = root
+	opts.ModulePath = "vendor/modules"
+
+	// a stack which is a symlink to somewhere outside of the root
+	assert.NoError(t, os.Symlink(outside, filepath.Join(root, "linked")))
+
+	back := chdir(t, root)
+	defer back()
+
+	for name, test := range map[string]struct {
+		module module
+		valid  bool
+	}{
+		"default destination":   {module: module{}, valid: true},
+		"nested destinations":   {module: module{Destinations: []string{"networking", "stacks/iam"}}, valid: true},
+		"absolute destination":  {module: module{Destinations: []string{"/"}}},
+		"parent destination":    {module: module{Destinations: []string{"networking", "../.."}}},
+		"symlinked destination": {module: module{Destinations: []string{"linked/stack"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			errs := checkPaths(map[string]module{"tf-aws-vpc": test.module})
+			if test.valid {
+				assert.Empty(t, errs)
+			} else {
+				assert.NotEmpty(t, errs)
+			}
+		})
+	}
+
+	errs := checkPaths(map[string]module{"../../etc": {}})
+	assert.NotEmpty(t, errs)
+
+	opts.ModulePath = "."
+	assert.NotEmpty(t, checkPaths(map[string]module{}))
+
+	opts.AllowOutsideRoot = true
+	assert.Empty(t, checkPaths(map[string]module{"tf-aws-vpc": {Destinations: []string{"/"}}}))
+}
+
+// chdir changes working directory to dir and returns a function changing it back
+func chdir(t *testing.T, dir string) func() {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	return func() {
+		assert.NoError(t, os.Chdir(wd))
+	}
+}
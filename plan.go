@@ -0,0 +1,76 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type planCommand struct{}
+
+// Execute prints every selected module of the Terrafile the way install sees
+// it, with variables interpolated
+func (c *planCommand) Execute(_ []string) error {
+	config, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	config, err = selectModules(config)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tSOURCE\tVERSION\tPATH")
+	for _, key := range keys {
+		m := config[key]
+		cloneDestination, linkDestinations := moduleDestinations(m)
+		path := filepath.Join(cloneDestination, key)
+		if len(m.Destinations) > 0 {
+			path += annotation(m, m.Destinations[0])
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, m.Source, m.Version, path)
+		for _, d := range linkDestinations {
+			fmt.Fprintf(w, "\t\t\t%s%s\n", filepath.Join(d, opts.ModulePath, key), annotation(m, d, "link"))
+		}
+	}
+
+	return w.Flush()
+}
+
+// annotation returns notes about destination of module m, like the glob
+// pattern it was expanded from, in parentheses
+func annotation(m module, destination string, notes ...string) string {
+	if note, ok := m.DestinationNotes[destination]; ok {
+		notes = append(notes, note)
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(notes, ", ") + ")"
+}
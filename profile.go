@@ -0,0 +1,174 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProfile names resolutions made without a profile in the lockfile
+const defaultProfile = "default"
+
+// profileFields lists every field a module may have in a profile
+var profileFields = []string{"source", "version", "destinations", "enabled"}
+
+// profileModule changes a module of the Terrafile in a profile
+type profileModule struct {
+	Source       string   `yaml:"source"`
+	Version      string   `yaml:"version"`
+	Destinations []string `yaml:"destinations"`
+	Enabled      *bool    `yaml:"enabled"`
+}
+
+// activeProfile returns name of the profile selected with --profile or
+// TERRAFILE_PROFILE, or the default profile
+func activeProfile() string {
+	if opts.Profile == "" {
+		return defaultProfile
+	}
+	return opts.Profile
+}
+
+// applyProfile applies changes of the profile selected with --profile to
+// modules of config and removes modules which aren't enabled in it. Versions
+// of changed modules are resolved with catalog, like those of the Terrafile.
+func applyProfile(config map[string]module, profiles map[string]map[string]profileModule, catalog *versionCatalog) []error {
+	changes, ok := profiles[opts.Profile]
+	if opts.Profile != "" && !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return []error{fmt.Errorf("unknown profile %q, profiles of %s are: %s", opts.Profile, opts.TerrafilePath, strings.Join(names, ", "))}
+	}
+
+	var errs []error
+	changed := make(map[string]module)
+	for key, change := range changes {
+		m, ok := config[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("profile %q changes unknown module %q", opts.Profile, key))
+			continue
+		}
+
+		if change.Source != "" {
+			m.Source = change.Source
+		}
+		if change.Version != "" {
+			m.Version = change.Version
+		}
+		if change.Destinations != nil {
+			m.Destinations = change.Destinations
+		}
+		if change.Enabled != nil {
+			m.Enabled = change.Enabled
+		}
+		config[key] = m
+
+		if change.Source != "" || change.Version != "" {
+			m.Origin = fmt.Sprintf("%s profile %q", opts.TerrafilePath, opts.Profile)
+			changed[key] = m
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if errs := resolveVersions(changed, catalog); len(errs) > 0 {
+		return errs
+	}
+	for key, m := range changed {
+		resolved := config[key]
+		resolved.Version = m.Version
+		config[key] = resolved
+	}
+
+	for key, m := range config {
+		if m.Enabled != nil && !*m.Enabled {
+			delete(config, key)
+		}
+	}
+
+	return nil
+}
+
+func (v *validator) validateProfiles(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "field \"profiles\" of %q section must be a mapping of profile names to module changes", settingsKey)
+		return
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		nameNode, profileNode := node.Content[i], node.Content[i+1]
+		name := nameNode.Value
+
+		if line, ok := seen[name]; ok {
+			v.errorf(nameNode, "duplicate profile %q, first defined on line %d", name, line)
+			continue
+		}
+		seen[name] = nameNode.Line
+
+		if name == defaultProfile || !moduleKeyPattern.MatchString(name) {
+			v.errorf(nameNode, "invalid profile name %q, names must start with a letter or digit, contain only letters, digits, '.', '_' and '-' and must not be %q", name, defaultProfile)
+		}
+		if profileNode.Kind != yaml.MappingNode {
+			v.errorf(profileNode, "profile %q must be a mapping of module names to changes", name)
+			continue
+		}
+
+		for j := 0; j+1 < len(profileNode.Content); j += 2 {
+			v.validateProfileModule(name, profileNode.Content[j].Value, profileNode.Content[j+1])
+		}
+	}
+}
+
+func (v *validator) validateProfileModule(profile string, key string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "module %q of profile %q must be a mapping with %s fields", key, profile, strings.Join(profileFields, ", "))
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fieldNode, valueNode := node.Content[i], node.Content[i+1]
+		field := fieldNode.Value
+
+		switch field {
+		case "source", "version":
+			if valueNode.Kind != yaml.ScalarNode || strings.TrimSpace(valueNode.Value) == "" {
+				v.errorf(valueNode, "field %q of module %q of profile %q must be a non-empty string", field, key, profile)
+			}
+		case "destinations":
+			v.validateDestinations(fmt.Sprintf("module %q of profile %q", key, profile), valueNode)
+		case "enabled":
+			if valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!bool" {
+				v.errorf(valueNode, "field \"enabled\" of module %q of profile %q must be true or false", key, profile)
+			}
+		default:
+			message := fmt.Sprintf("unknown field %q in module %q of profile %q", field, key, profile)
+			if suggestion := closest(field, profileFields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.errorf(fieldNode, "%s", message)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const profilesTerrafile = `terrafile:
+  profiles:
+    dev:
+      tf-aws-vpc:
+        version: v2.0.0
+      tf-aws-debug:
+        enabled: true
+    prod:
+      tf-aws-vpc:
+        source:       "git@github.com:mirror/terraform-aws-vpc"
+        destinations: [production]
+tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+tf-aws-debug:
+  source:  "git@github.com:platform/tf-aws-debug"
+  version: "v1.0.0"
+  enabled: false
+`
+
+func TestApplyProfile(t *testing.T) {
+	defer restoreOpts()()
+	opts.TerrafilePath = "Terrafile"
+
+	for profile, expected := range map[string]map[string]module{
+		"": {
+			"tf-aws-vpc": {Source: "git@github.com:terraform-aws-modules/terraform-aws-vpc", Version: "v1.46.0"},
+		},
+		"dev": {
+			"tf-aws-vpc":   {Source: "git@github.com:terraform-aws-modules/terraform-aws-vpc", Version: "v2.0.0"},
+			"tf-aws-debug": {Source: "git@github.com:platform/tf-aws-debug", Version: "v1.0.0"},
+		},
+		"prod": {
+			"tf-aws-vpc": {Source: "git@github.com:mirror/terraform-aws-vpc", Version: "v1.46.0", Destinations: []string{"production"}},
+		},
+	} {
+		t.Run("profile "+profile, func(t *testing.T) {
+			opts.Profile = profile
+			config, settings, errs := parseTerrafile("Terrafile", []byte(profilesTerrafile))
+			assert.Empty(t, errs)
+			assert.Empty(t, applyProfile(config, settings.Profiles, nil))
+
+			for key := range config {
+				m := config[key]
+				m.Enabled = nil
+				config[key] = m
+			}
+			assert.Equal(t, expected, config)
+		})
+	}
+
+	opts.Profile = "staging"
+	config, settings, _ := parseTerrafile("Terrafile", []byte(profilesTerrafile))
+	errs := applyProfile(config, settings.Profiles, nil)
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], `unknown profile "staging", profiles of Terrafile are: dev, prod`)
+	}
+}
+
+func TestLoadTerrafileProfileCatalog(t *testing.T) {
+	defer restoreOpts()()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	vpc := "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+	opts.TerrafilePath = "Terrafile"
+	createFile(t, "Terrafile.catalog", fmt.Sprintf("%q: v1.46.0\n", vpc))
+	createFile(t, "Terrafile", fmt.Sprintf(`terrafile:
+  catalog: Terrafile.catalog
+  profiles:
+    dev:
+      tf-aws-vpc:
+        version: v2.0.0
+    approved:
+      tf-aws-vpc:
+        source:  %q
+        version: catalog
+tf-aws-vpc:
+  source:  "git@github.com:mirror/terraform-aws-vpc"
+  version: "v1.0.0"
+`, vpc))
+
+	// versions of a profile are resolved with the catalog of the Terrafile
+	opts.Profile = "approved"
+	config, err := loadTerrafile()
+	assert.NoError(t, err)
+	assert.Equal(t, module{Source: vpc, Version: "v1.46.0", Origin: "Terrafile"}, config["tf-aws-vpc"])
+
+	// and have to be approved by it
+	opts.Profile = "dev"
+	errs := applyProfile(map[string]module{"tf-aws-vpc": {Source: vpc, Version: "v1.46.0", Origin: "Terrafile"}},
+		map[string]map[string]profileModule{"dev": {"tf-aws-vpc": {Version: "v2.0.0"}}},
+		&versionCatalog{origin: "Terrafile.catalog", versions: map[string]string{vpc: "v1.46.0"}})
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], `Terrafile profile "dev": module "tf-aws-vpc" pins v2.0.0 of `+vpc+`, but catalog Terrafile.catalog approves v1.46.0, set `+"`override: true`"+` to pin a different version`)
+	}
+	createFile(t, "Terrafile", fmt.Sprintf(`terrafile:
+  catalog: Terrafile.catalog
+  profiles:
+    dev:
+      tf-aws-vpc:
+        version: v2.0.0
+tf-aws-vpc:
+  source:  %q
+`, vpc))
+	_, err = loadTerrafile()
+	assert.EqualError(t, err, "failed to load configuration from file Terrafile due to 1 error(s)")
+}
+
+func TestParseTerrafileProfilesErrors(t *testing.T) {
+	_, _, errs := parseTerrafile("Terrafile", []byte(`terrafile:
+  profiles:
+    default:
+      tf-aws-vpc:
+        verison: v2.0.0
+        enabled: maybe
+`))
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		`Terrafile:3:5: invalid profile name "default", names must start with a letter or digit, contain only letters, digits, '.', '_' and '-' and must not be "default"`,
+		`Terrafile:5:9: unknown field "verison" in module "tf-aws-vpc" of profile "default", did you mean "version"?`,
+		`Terrafile:6:18: field "enabled" of module "tf-aws-vpc" of profile "default" must be true or false`,
+	}, messages)
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// protectModules makes sure no vendored module with local modifications is
+// replaced, unless the user asked to either stash or discard the modifications
+func protectModules(config map[string]module, unselected map[string]bool) error {
+	modified := make(map[string][]string)
+
+	for _, dir := range replacedModuleDirs(config, unselected) {
+		changes, err := localModifications(dir)
+		if err != nil {
+			return fmt.Errorf("failed to check %s for local modifications due to error: %s", dir, err)
+		}
+		if len(changes) > 0 {
+			modified[dir] = changes
+		}
+	}
+
+	if len(modified) == 0 {
+		return nil
+	}
+
+	dirs := make([]string, 0, len(modified))
+	for dir := range modified {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		log.Warnf("[*] Local modifications found in %s:", dir)
+		for _, change := range modified[dir] {
+			log.Warnf("[*]     %s", change)
+		}
+	}
+
+	switch {
+	case opts.Stash:
+		for _, dir := range dirs {
+			patch, err := stashModule(dir)
+			if err != nil {
+				return fmt.Errorf("failed to stash local modifications of %s due to error: %s", dir, err)
+			}
+			log.Infof("[*] Saved local modifications of %s to %s", dir, patch)
+		}
+	case opts.Force:
+		log.Warnf("[*] Discarding local modifications of %d module(s) as --force is set", len(dirs))
+	default:
+		return fmt.Errorf("refusing to replace %d locally modified module(s), re-run with --stash to save the modifications as patch files or --force to discard them", len(dirs))
+	}
+
+	return nil
+}
+
+// replacedModuleDirs lists every existing module folder the run is about to
+// remove, unselected modules are left alone by cleaning and pruning
+func replacedModuleDirs(config map[string]module, unselected map[string]bool) []string {
+	unique := make(map[string]bool)
+
+	// module path is pruned on every run
+	parents := []string{opts.ModulePath}
+	if opts.Clean {
+		for dst := range uniqueDestinations(config) {
+			parents = append(parents, dst)
+		}
+	}
+	for _, parent := range parents {
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !unselected[entry.Name()] {
+				unique[filepath.Join(parent, entry.Name())] = true
+			}
+		}
+	}
+
+	for key, m := range config {
+		cloneDestination, linkDestinations := moduleDestinations(m)
+		unique[filepath.Join(cloneDestination, key)] = true
+		for _, d := range linkDestinations {
+			unique[filepath.Join(d, opts.ModulePath, key)] = true
+		}
+	}
+
+	var dirs []string
+	for dir := range unique {
+		// links point to modules cloned elsewhere, which are checked on their own
+		if info, err := os.Lstat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	return dirs
+}
+
+// localModifications lists files changed since the module was installed, in
+// `git status --porcelain` format. Modules are compared against hashes
+// recorded in terrafile metadata or, for clones without it, against git.
+func localModifications(dir string) ([]string, error) {
+	metadata, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		files, _, err := hashModule(dir)
+		if err != nil {
+			return nil, err
+		}
+		return diffFiles(metadata.Files, files), nil
+	}
+
+	// not installed by terrafile, nothing to compare against
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return nil, nil
+	}
+
+	out, err := gitOutput(dir, "status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			changes = append(changes, line)
+		}
+	}
+
+	return changes, nil
+}
+
+// stashModule saves local modifications of the module in dir as a patch file
+// inside stash folder, which can be re-applied with `git apply --directory=<dir>`
+func stashModule(dir string) (string, error) {
+	if err := os.MkdirAll(opts.StashDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	metadata, err := readMetadata(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// exported modules have no git metadata, so the files as they were installed
+	// are rebuilt in a temporary repository the module is compared against
+	var git []string
+	base := "HEAD"
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil && metadata != nil {
+		gitDir, err := os.MkdirTemp("", "terrafile-stash-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(gitDir)
+		baseline, err := os.MkdirTemp("", "terrafile-stash-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(baseline)
+
+		if err := installedBaseline(dir, baseline, *metadata); err != nil {
+			return "", fmt.Errorf("failed to rebuild the installed module due to error: %s", err)
+		}
+		git = []string{"--git-dir=" + gitDir}
+		if _, err := gitOutput(baseline, append(git, "init", "--quiet")...); err != nil {
+			return "", err
+		}
+		if _, err := gitOutput(baseline, append(git, "--work-tree=.", "add", "--all")...); err != nil {
+			return "", err
+		}
+		tree, err := gitOutput(baseline, append(git, "write-tree")...)
+		if err != nil {
+			return "", err
+		}
+		base = strings.TrimSpace(string(tree))
+
+		workTree, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		git = append(git, "--work-tree="+workTree)
+	}
+
+	// stage everything, including new files, so that they end up in the patch
+	if _, err := gitOutput(dir, append(git, "add", "--all", "--", ".", ":!"+metadataFile)...); err != nil {
+		return "", err
+	}
+	diff, err := gitOutput(dir, append(git, "diff", "--cached", "--binary", base)...)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.ReplaceAll(filepath.ToSlash(filepath.Clean(dir)), "/", "_")
+	return writeNewFile(opts.StashDir, fmt.Sprintf("%s-%s", name, time.Now().Format("20060102T150405")), ".patch", diff)
+}
+
+// writeNewFile writes data to a new file name+ext in dir, numbering the name
+// until it doesn't overwrite an existing file, and returns the file written
+func writeNewFile(dir string, name string, ext string, data []byte) (string, error) {
+	for n := 1; ; n++ {
+		filename := filepath.Join(dir, name+ext)
+		if n > 1 {
+			filename = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, n, ext))
+		}
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return "", err
+		}
+		return filename, f.Close()
+	}
+}
+
+// installedBaseline fills folder baseline with the files of the module in dir
+// as they were installed: the recorded commit is checked out, content filters
+// and source rewrites are applied to it again and modules of nested Terrafiles
+// are rebuilt the same way. Files other steps of the install changed are
+// taken from dir, as long as they are unmodified.
+func installedBaseline(dir string, baseline string, metadata moduleMetadata) error {
+	if err := checkoutCommit(metadata.Source, metadata.Commit, baseline); err != nil {
+		return err
+	}
+
+	var include, exclude []string
+	for _, filter := range metadata.Filters {
+		switch {
+		case strings.HasPrefix(filter, "include:"):
+			include = append(include, strings.TrimPrefix(filter, "include:"))
+		case strings.HasPrefix(filter, "exclude:"):
+			exclude = append(exclude, strings.TrimPrefix(filter, "exclude:"))
+		}
+	}
+	if _, err := filterModule(baseline, newContentFilter(include, exclude)); err != nil {
+		return err
+	}
+	if metadata.Transitive {
+		if _, err := vendorSources(baseline, module{Source: metadata.Source, Version: metadata.Version}); err != nil {
+			return err
+		}
+	}
+
+	// modules of nested Terrafiles, the outermost ones rebuild those below them.
+	// Folders end with a slash, so that those below a folder sort right after it.
+	var nested []string
+	for file := range metadata.Files {
+		if path.Base(file) == metadataFile {
+			nested = append(nested, path.Dir(file)+"/")
+		}
+	}
+	sort.Strings(nested)
+	outer := ""
+	for _, moduleDir := range nested {
+		if outer != "" && strings.HasPrefix(moduleDir, outer) {
+			continue
+		}
+		outer = moduleDir
+		nestedMetadata, err := readMetadata(filepath.Join(dir, filepath.FromSlash(moduleDir)))
+		if err != nil || nestedMetadata == nil {
+			continue
+		}
+		nestedBaseline := filepath.Join(baseline, filepath.FromSlash(moduleDir))
+		if err := os.RemoveAll(nestedBaseline); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(nestedBaseline, os.ModePerm); err != nil {
+			return err
+		}
+		if err := installedBaseline(filepath.Join(dir, filepath.FromSlash(moduleDir)), nestedBaseline, *nestedMetadata); err != nil {
+			return err
+		}
+	}
+
+	files, _, err := hashModule(baseline)
+	if err != nil {
+		return err
+	}
+	installed, _, err := hashModule(dir)
+	if err != nil {
+		return err
+	}
+	for file := range files {
+		if _, ok := metadata.Files[file]; !ok {
+			if err := os.Remove(filepath.Join(baseline, filepath.FromSlash(file))); err != nil {
+				return err
+			}
+		}
+	}
+	for file, hash := range metadata.Files {
+		if files[file] == hash || installed[file] != hash {
+			continue
+		}
+		target := filepath.Join(baseline, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		source := filepath.Join(dir, filepath.FromSlash(file))
+		info, err := os.Lstat(source)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(source)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(link, target)
+		} else {
+			err = copyFile(source, target, info.Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkoutCommit checks out commit of source into dir, without git metadata
+func checkoutCommit(source string, commit string, dir string) error {
+	gitDir, err := os.MkdirTemp("", "terrafile-stash-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(gitDir)
+
+	git := []string{"--git-dir=" + gitDir, "--work-tree=."}
+	if _, err := gitOutput(dir, append(git, "init", "--quiet")...); err != nil {
+		return err
+	}
+	err = fetchMirrored(source, func(location string) error {
+		if err := checkSource(location, commit); err != nil {
+			return err
+		}
+		_, err := gitOutput(dir, append(git, "fetch", "--quiet", "--depth=1", "--", location, commit)...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = gitOutput(dir, append(git, "reset", "--quiet", "--hard", "FETCH_HEAD")...)
+	return err
+}
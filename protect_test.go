@@ -0,0 +1,112 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalModifications(t *testing.T) {
+	dir := t.TempDir()
+	createGitModule(t, dir)
+
+	changes, err := localModifications(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	createFile(t, filepath.Join(dir, "main.tf"), "# hotfix\n")
+	createFile(t, filepath.Join(dir, "extra.tf"), "# new file\n")
+
+	changes, err = localModifications(dir)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{" M main.tf", "?? extra.tf"}, changes)
+
+	// folders without git metadata can't be compared
+	changes, err = localModifications(t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestWriteNewFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, expected := range []string{"a_b-1.patch", "a_b-1-2.patch", "a_b-1-3.patch"} {
+		filename, err := writeNewFile(dir, "a_b-1", ".patch", []byte(expected))
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, expected), filename)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "a_b-1.patch"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a_b-1.patch", string(data))
+}
+
+func TestProtectModules(t *testing.T) {
+	root := t.TempDir()
+	defer restoreOpts()()
+	opts.ModulePath = filepath.Join(root, "vendor/modules")
+	opts.StashDir = filepath.Join(root, "stash")
+
+	dir := filepath.Join(opts.ModulePath, "tf-aws-vpc")
+	assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	createGitModule(t, dir)
+	config := map[string]module{"tf-aws-vpc": {Source: "unused", Version: "v1.0.0"}}
+
+	// pristine modules are replaced silently
+	assert.NoError(t, protectModules(config, nil))
+
+	createFile(t, filepath.Join(dir, "main.tf"), "# hotfix\n")
+	assert.Error(t, protectModules(config, nil))
+
+	opts.Force = true
+	assert.NoError(t, protectModules(config, nil))
+	assert.NoDirExists(t, opts.StashDir)
+
+	opts.Force = false
+	opts.Stash = true
+	assert.NoError(t, protectModules(config, nil))
+	patches, err := filepath.Glob(filepath.Join(opts.StashDir, "*.patch"))
+	assert.NoError(t, err)
+	if assert.Len(t, patches, 1) {
+		contents, err := os.ReadFile(patches[0])
+		assert.NoError(t, err)
+		assert.Contains(t, string(contents), "+# hotfix")
+	}
+}
+
+// createGitModule initializes dir as a git repository with a single committed main.tf
+func createGitModule(t *testing.T, dir string) {
+	createFile(t, filepath.Join(dir, "main.tf"), "# module\n")
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "--all"},
+		{"-c", "user.name=terrafile", "-c", "user.email=terrafile@example.com", "commit", "--quiet", "-m", "init"},
+	} {
+		_, err := gitOutput(dir, args...)
+		assert.NoError(t, err)
+	}
+}
+
+// restoreOpts returns a function that resets opts to the values it had when called
+func restoreOpts() func() {
+	saved := opts
+	return func() {
+		opts = saved
+	}
+}
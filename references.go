@@ -0,0 +1,211 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// moduleReference is a `module` block of a terraform file using a vendored module
+type moduleReference struct {
+	// Key of the vendored module
+	Key string
+	// Destination is the folder the vendored module is expected in
+	Destination string
+	// File and Line of the `module` block
+	File string
+	Line int
+}
+
+// findReferences scans every terraform file under the current folder for
+// `module` blocks whose source points into a module path
+func findReferences() ([]moduleReference, error) {
+	modulePath := path.Clean(filepath.ToSlash(opts.ModulePath))
+
+	var references []moduleReference
+	err := walkTerraformFiles(func(file string, data string) error {
+		for _, block := range scanModuleBlocks(data) {
+			if key, destination, ok := referencedModule(path.Dir(filepath.ToSlash(file)), block.Source, modulePath); ok {
+				references = append(references, moduleReference{Key: key, Destination: destination, File: file, Line: block.Line})
+			}
+		}
+		return nil
+	})
+
+	return references, err
+}
+
+// walkTerraformFiles calls fn with name and contents of every terraform file
+// under the current folder. Hidden folders and module paths are skipped.
+func walkTerraformFiles(fn func(file string, data string) error) error {
+	modulePath := path.Clean(filepath.ToSlash(opts.ModulePath))
+
+	return filepath.WalkDir(".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel := filepath.ToSlash(p)
+		if entry.IsDir() {
+			if rel != "." && (strings.HasPrefix(entry.Name(), ".") || rel == modulePath || strings.HasSuffix(rel, "/"+modulePath)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".tf" {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return fn(p, string(data))
+	})
+}
+
+// referencedModule returns key and destination of the vendored module local
+// source of a module block in folder dir points to
+func referencedModule(dir string, source string, modulePath string) (key string, destination string, ok bool) {
+	if !strings.HasPrefix(source, "./") && !strings.HasPrefix(source, "../") {
+		return "", "", false
+	}
+
+	resolved := path.Join(dir, source)
+	i := strings.Index("/"+resolved+"/", "/"+modulePath+"/")
+	if i < 0 {
+		return "", "", false
+	}
+
+	destination = "."
+	if i > 0 {
+		destination = resolved[:i-1]
+	}
+	rest := strings.TrimPrefix(resolved[i:], modulePath)
+	key = strings.Split(strings.TrimPrefix(rest, "/"), "/")[0]
+	if key == "" {
+		return "", "", false
+	}
+
+	return key, filepath.FromSlash(destination), true
+}
+
+// discoverDestinations sets destinations of modules of config without any to
+// the folders whose terraform files reference them
+func discoverDestinations(config map[string]module) error {
+	references, err := findReferences()
+	if err != nil {
+		return fmt.Errorf("failed to scan terraform files for module references due to error: %s", err)
+	}
+
+	referenced := make(map[string]map[string]string)
+	for _, r := range references {
+		if _, ok := config[r.Key]; !ok {
+			log.Warnf("[*] %s:%d references module %s, which is not in %s", r.File, r.Line, r.Key, opts.TerrafilePath)
+			continue
+		}
+		if referenced[r.Key] == nil {
+			referenced[r.Key] = make(map[string]string)
+		}
+		if _, ok := referenced[r.Key][r.Destination]; !ok {
+			referenced[r.Key][r.Destination] = fmt.Sprintf("%s:%d", r.File, r.Line)
+		}
+	}
+
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		if m.Destinations != nil {
+			continue
+		}
+		if len(referenced[key]) == 0 {
+			log.Warnf("[*] Module %s is not referenced by any terraform file, installing it into %s", key, opts.ModulePath)
+			continue
+		}
+
+		destinations := make([]string, 0, len(referenced[key]))
+		for destination := range referenced[key] {
+			destinations = append(destinations, destination)
+		}
+		sort.Strings(destinations)
+
+		m.Destinations = destinations
+		m.DestinationNotes = make(map[string]string)
+		for _, destination := range destinations {
+			m.DestinationNotes[destination] = "used by " + referenced[key][destination]
+		}
+		config[key] = m
+	}
+
+	return nil
+}
+
+type referencesCommand struct{}
+
+// Execute prints every reference of terraform files to a vendored module
+func (c *referencesCommand) Execute(_ []string) error {
+	config, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+
+	references, err := findReferences()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(references, func(i, j int) bool {
+		if references[i].Key != references[j].Key {
+			return references[i].Key < references[j].Key
+		}
+		return references[i].Destination < references[j].Destination
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tSTACK\tREFERENCE\tSTATE")
+	for _, r := range references {
+		state := "ok"
+		if m, ok := config[r.Key]; !ok {
+			state = "not in Terrafile"
+		} else if !installedInto(m, r.Destination) {
+			state = "not installed here"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s:%d\t%s\n", r.Key, r.Destination, r.File, r.Line, state)
+	}
+
+	return w.Flush()
+}
+
+// installedInto reports whether module m is installed or linked into destination
+func installedInto(m module, destination string) bool {
+	if len(m.Destinations) == 0 {
+		return filepath.Clean(destination) == "."
+	}
+	for _, d := range m.Destinations {
+		if filepath.Clean(d) == filepath.Clean(destination) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferencedModule(t *testing.T) {
+	for name, test := range map[string]struct {
+		dir, source, key, destination string
+	}{
+		"stack":       {dir: "stacks/networking", source: "./vendor/modules/tf-aws-vpc", key: "tf-aws-vpc", destination: "stacks/networking"},
+		"submodule":   {dir: "stacks/networking", source: "./vendor/modules/tf-aws-iam//modules/role", key: "tf-aws-iam", destination: "stacks/networking"},
+		"parent":      {dir: "stacks/networking", source: "../../vendor/modules/tf-aws-vpc", key: "tf-aws-vpc", destination: "."},
+		"root":        {dir: ".", source: "./vendor/modules/tf-aws-vpc", key: "tf-aws-vpc", destination: "."},
+		"registry":    {dir: ".", source: "terraform-aws-modules/vpc/aws"},
+		"other local": {dir: ".", source: "./modules/vpc"},
+		"module path": {dir: ".", source: "./vendor/modules"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			key, destination, ok := referencedModule(test.dir, test.source, "vendor/modules")
+			assert.Equal(t, test.key != "", ok)
+			assert.Equal(t, test.key, key)
+			assert.Equal(t, test.destination, destination)
+		})
+	}
+}
+
+func TestDiscoverDestinations(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{"stacks/networking", "stacks/onboarding", "stacks/.terraform", "stacks/networking/vendor/modules/tf-aws-vpc"} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+	createFile(t, "stacks/networking/main.tf", "module \"vpc\" {\n  source = \"./vendor/modules/tf-aws-vpc\"\n}\n")
+	createFile(t, "stacks/onboarding/main.tf", "\nmodule \"vpc\" {\n  source = \"./vendor/modules/tf-aws-vpc\"\n}\nmodule \"unknown\" {\n  source = \"./vendor/modules/tf-aws-unknown\"\n}\n")
+	createFile(t, "stacks/.terraform/main.tf", "module \"vpc\" {\n  source = \"./vendor/modules/tf-aws-iam\"\n}\n")
+	createFile(t, "stacks/networking/vendor/modules/tf-aws-vpc/main.tf", "module \"nested\" {\n  source = \"./vendor/modules/tf-aws-iam\"\n}\n")
+
+	config := map[string]module{
+		"tf-aws-vpc":    {},
+		"tf-aws-iam":    {},
+		"tf-aws-pinned": {Destinations: []string{"pinned"}},
+	}
+	assert.NoError(t, discoverDestinations(config))
+
+	assert.Equal(t, []string{"stacks/networking", "stacks/onboarding"}, config["tf-aws-vpc"].Destinations)
+	assert.Equal(t, map[string]string{
+		"stacks/networking": "used by stacks/networking/main.tf:1",
+		"stacks/onboarding": "used by stacks/onboarding/main.tf:2",
+	}, config["tf-aws-vpc"].DestinationNotes)
+	assert.Nil(t, config["tf-aws-iam"].Destinations)
+	assert.Equal(t, []string{"pinned"}, config["tf-aws-pinned"].Destinations)
+}
+
+func TestLoadTerrafileAutoDestinationsModulePath(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{"stacks/app/modules/vendored/vpc/examples", "stacks/db"} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+	createFile(t, "stacks/app/main.tf", "module \"vpc\" {\n  source = \"./modules/vendored/vpc\"\n}\n")
+	createFile(t, "stacks/app/modules/vendored/vpc/examples/main.tf", "module \"iam\" {\n  source = \"../../modules/vendored/iam\"\n}\n")
+	opts.TerrafilePath = "Terrafile"
+	createFile(t, "Terrafile", `terrafile:
+  module_path: modules/vendored
+  auto_destinations: true
+vpc:
+  source: "git@github.com:org/terraform-aws-vpc"
+  version: "v1.0.0"
+iam:
+  source: "git@github.com:org/terraform-aws-iam"
+  version: "v1.0.0"
+  destinations: ["stacks/**"]
+`)
+
+	config, err := loadTerrafile()
+	assert.NoError(t, err)
+	assert.Equal(t, "modules/vendored", opts.ModulePath)
+	assert.Equal(t, []string{"stacks/app"}, config["vpc"].Destinations)
+	// the module path of the settings section and folders leading to it are never destinations
+	assert.Equal(t, []string{"stacks", "stacks/app", "stacks/db"}, config["iam"].Destinations)
+	for _, d := range config["iam"].Destinations {
+		assert.NotContains(t, filepath.ToSlash(d)+"/", "modules/vendored/", d)
+		assert.NoDirExists(t, filepath.Join(d, "vendored"), d)
+	}
+
+	// nor once modules were installed into the destinations
+	for _, d := range config["iam"].Destinations {
+		assert.NoError(t, os.MkdirAll(filepath.Join(d, opts.ModulePath, "iam"), os.ModePerm))
+	}
+	again, err := loadTerrafile()
+	assert.NoError(t, err)
+	assert.Equal(t, config["iam"].Destinations, again["iam"].Destinations)
+
+	references, err := findReferences()
+	assert.NoError(t, err)
+	assert.Len(t, references, 1)
+	assert.True(t, installedInto(config["vpc"], references[0].Destination))
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/sirupsen/logrus"
+)
+
+type rewriteSourcesCommand struct {
+	ToRemote bool `long:"to-remote" description:"Point module blocks using vendored modules back to their remote source"`
+	DryRun   bool `long:"dry-run" description:"Only show the changes, without writing them"`
+}
+
+// sourceEdit replaces the source of a module block of file
+type sourceEdit struct {
+	File   string
+	Block  moduleBlock
+	Source string
+}
+
+// Execute points module blocks with the remote source of a module of the
+// Terrafile to the vendored module, or vendored modules back to their remote
+// source with --to-remote
+func (c *rewriteSourcesCommand) Execute(_ []string) error {
+	allModules, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	if overrides := activeOverrides(allModules); len(overrides) > 0 {
+		return fmt.Errorf("refusing to rewrite module sources while %d module(s) are overridden, remove the override file first", len(overrides))
+	}
+	config, err := selectModules(allModules)
+	if err != nil {
+		return err
+	}
+
+	edits, err := sourceEdits(config, c.ToRemote)
+	if err != nil {
+		return err
+	}
+	if len(edits) == 0 {
+		log.Infof("[*] Found no module source to rewrite")
+		return nil
+	}
+
+	rewritten, err := rewriteSources(edits)
+	if err != nil {
+		return err
+	}
+	if err := printRewrites(rewritten); err != nil {
+		return err
+	}
+	if c.DryRun {
+		log.Infof("[*] Would rewrite %d module source(s) in %d file(s)", len(edits), len(rewritten))
+		return nil
+	}
+
+	return writeRewrites(rewritten)
+}
+
+// sourceEdits returns the edits pointing module blocks of terraform files
+// under the current folder using modules of config to the vendored modules,
+// or back to their remote source if toRemote is set
+func sourceEdits(config map[string]module, toRemote bool) ([]sourceEdit, error) {
+	var edits []sourceEdit
+	err := walkTerraformFiles(func(file string, data string) error {
+		for _, block := range scanModuleBlocks(data) {
+			source, ok := vendoredSource(config, file, block)
+			if toRemote {
+				source, ok = remoteSourceOf(config, file, block)
+			}
+			if ok && source != block.Source {
+				edits = append(edits, sourceEdit{File: file, Block: block, Source: source})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan terraform files for module blocks due to error: %s", err)
+	}
+	return edits, nil
+}
+
+// vendoredSource returns the path of the vendored module a module block of
+// file with a remote source should use instead
+func vendoredSource(config map[string]module, file string, block moduleBlock) (string, bool) {
+	if block.Source == "" || strings.HasPrefix(block.Source, "./") || strings.HasPrefix(block.Source, "../") {
+		return "", false
+	}
+	remote, err := parseModuleSource(block.Source, block.Version)
+	if err != nil {
+		return "", false
+	}
+
+	var versions []string
+	for _, key := range sortedKeys(config) {
+		m := config[key]
+		if catalogKey(m.Source) != catalogKey(remote.Source) {
+			continue
+		}
+		if m.Version != remote.Version {
+			versions = append(versions, m.Version)
+			continue
+		}
+
+		source, ok := vendoredPath(key, m, filepath.Dir(file), remote.Subdir)
+		if !ok {
+			log.Warnf("[*] %s:%d: module %s is not installed into %s or a folder above it, skipping module %q", file, block.Line, key, filepath.Dir(file), block.Name)
+		}
+		return source, ok
+	}
+
+	if len(versions) > 0 {
+		log.Warnf("[*] %s:%d: module %q uses %s of %s, but %s provides %s, skipping it", file, block.Line, block.Name, remote.Version, remote.Source, opts.TerrafilePath, strings.Join(versions, ", "))
+	}
+	return "", false
+}
+
+// vendoredPath returns the path of folder subdir of module key relative to
+// folder dir, using the destination of the module closest to dir
+func vendoredPath(key string, m module, dir string, subdir string) (string, bool) {
+	dir = path.Clean(filepath.ToSlash(dir))
+	destinations := m.Destinations
+	if len(destinations) == 0 {
+		destinations = []string{"."}
+	}
+
+	closest, found := "", false
+	for _, d := range destinations {
+		d = path.Clean(filepath.ToSlash(d))
+		above := d == "." || d == dir || strings.HasPrefix(dir, d+"/")
+		if above && (!found || len(d) > len(closest)) {
+			closest, found = d, true
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	target := path.Join(closest, filepath.ToSlash(opts.ModulePath), key, subdir)
+	rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(target))
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel, true
+}
+
+// remoteSourceOf returns the remote source a module block of file using a
+// vendored module should use instead
+func remoteSourceOf(config map[string]module, file string, block moduleBlock) (string, bool) {
+	modulePath := path.Clean(filepath.ToSlash(opts.ModulePath))
+	dir := path.Dir(filepath.ToSlash(file))
+
+	key, destination, ok := referencedModule(dir, block.Source, modulePath)
+	if !ok {
+		return "", false
+	}
+	m, ok := config[key]
+	if !ok {
+		log.Warnf("[*] %s:%d: module %q uses module %s, which is not in %s, skipping it", file, block.Line, block.Name, key, opts.TerrafilePath)
+		return "", false
+	}
+
+	vendored := path.Join(filepath.ToSlash(destination), modulePath, key)
+	subdir := strings.Trim(strings.TrimPrefix(path.Join(dir, block.Source), vendored), "/")
+
+	return terraformSource(m.Source, m.Version, subdir), true
+}
+
+// terraformSource returns the terraform module source of folder subdir of
+// the git repository at source, checked out at version
+func terraformSource(source string, version string, subdir string) string {
+	if subdir != "" {
+		source += "//" + subdir
+	}
+	source += "?ref=" + url.QueryEscape(version)
+	if !strings.HasPrefix(source, "git@") {
+		source = "git::" + source
+	}
+	return source
+}
+
+// rewriteSources returns new contents of every file with a module block
+// edited. Versions are removed from edited blocks, terraform allows them only
+// for registry sources.
+func rewriteSources(edits []sourceEdit) (map[string]string, error) {
+	byFile := make(map[string][]sourceEdit)
+	for _, e := range edits {
+		byFile[e.File] = append(byFile[e.File], e)
+	}
+
+	rewritten := make(map[string]string)
+	for file, fileEdits := range byFile {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s due to error: %s", file, err)
+		}
+		content := string(data)
+
+		type replacement struct {
+			start, end int
+			text       string
+		}
+		var replacements []replacement
+		for _, e := range fileEdits {
+			replacements = append(replacements, replacement{e.Block.SourceSpan[0], e.Block.SourceSpan[1], quoteHCLString(e.Source)})
+			if e.Block.Version != "" {
+				start, end := lineSpan(content, e.Block.VersionSpan[0], e.Block.VersionSpan[1])
+				replacements = append(replacements, replacement{start, end, ""})
+			}
+		}
+
+		// replace back to front so earlier offsets stay valid
+		sort.Slice(replacements, func(i, j int) bool { return replacements[i].start > replacements[j].start })
+		for _, r := range replacements {
+			content = content[:r.start] + r.text + content[r.end:]
+		}
+		rewritten[file] = content
+	}
+
+	return rewritten, nil
+}
+
+// printRewrites prints a diff of every rewritten file
+func printRewrites(rewritten map[string]string) error {
+	for _, file := range sortedFiles(rewritten) {
+		before, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		diff, err := unifiedDiff(filepath.ToSlash(file), string(before), rewritten[file])
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+	}
+	return nil
+}
+
+// writeRewrites writes every rewritten file
+func writeRewrites(rewritten map[string]string) error {
+	for _, file := range sortedFiles(rewritten) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite module sources in %s due to error: %s", file, err)
+		}
+		if err := os.WriteFile(file, []byte(rewritten[file]), info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to rewrite module sources in %s due to error: %s", file, err)
+		}
+		log.Infof("[*] Rewrote module sources in %s", file)
+	}
+	return nil
+}
+
+// lineSpan widens the span from start to end to its whole line, including the
+// line break, if nothing but blanks share the line with it
+func lineSpan(content string, start int, end int) (int, int) {
+	lineStart := start
+	for lineStart > 0 && (content[lineStart-1] == ' ' || content[lineStart-1] == '\t') {
+		lineStart--
+	}
+	lineEnd := end
+	for lineEnd < len(content) && (content[lineEnd] == ' ' || content[lineEnd] == '\t' || content[lineEnd] == '\r') {
+		lineEnd++
+	}
+	if (lineStart > 0 && content[lineStart-1] != '\n') || (lineEnd < len(content) && content[lineEnd] != '\n') {
+		return start, end
+	}
+	if lineEnd < len(content) {
+		lineEnd++
+	}
+	return lineStart, lineEnd
+}
+
+func sortedFiles(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unifiedDiff returns a unified diff of file name changing from before to
+// after, with three lines of context around changes
+func unifiedDiff(name string, before string, after string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(before),
+		B:        splitLines(after),
+		FromFile: "a/" + name,
+		ToFile:   "b/" + name,
+		Context:  3,
+	})
+}
+
+// splitLines splits s into lines which all end with a newline, as the diff
+// prints them as they are
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
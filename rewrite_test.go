@@ -0,0 +1,157 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVendoredPath(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+
+	for name, test := range map[string]struct {
+		destinations []string
+		dir, subdir  string
+		expected     string
+	}{
+		"root":          {dir: ".", expected: "./vendor/modules/tf-aws-iam"},
+		"stack of root": {dir: "stacks/app", expected: "../../vendor/modules/tf-aws-iam"},
+		"destination":   {destinations: []string{"stacks/app"}, dir: "stacks/app", subdir: "modules/role", expected: "./vendor/modules/tf-aws-iam/modules/role"},
+		"closest":       {destinations: []string{".", "stacks"}, dir: "stacks/app", expected: "../vendor/modules/tf-aws-iam"},
+		"elsewhere":     {destinations: []string{"stacks/db"}, dir: "stacks/app"},
+		"prefix":        {destinations: []string{"stacks/app"}, dir: "stacks/application"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			source, ok := vendoredPath("tf-aws-iam", module{Destinations: test.destinations}, test.dir, test.subdir)
+			assert.Equal(t, test.expected != "", ok)
+			assert.Equal(t, test.expected, source)
+		})
+	}
+}
+
+func TestTerraformSource(t *testing.T) {
+	assert.Equal(t, "git::https://github.com/org/tf-aws-iam.git//modules/role?ref=v1.0.0", terraformSource("https://github.com/org/tf-aws-iam.git", "v1.0.0", "modules/role"))
+	assert.Equal(t, "git@github.com:org/tf-aws-vpc.git?ref=v1.0.0", terraformSource("git@github.com:org/tf-aws-vpc.git", "v1.0.0", ""))
+}
+
+func TestSourceEdits(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	assert.NoError(t, os.MkdirAll("stacks/app", os.ModePerm))
+	remote := `module "role" {
+  # the role of the app
+  source = "git::https://github.com/org/tf-aws-iam.git//modules/role?ref=v1.0.0"
+  name   = "app"
+}
+
+module "vpc" {
+  source  = "git@github.com:org/tf-aws-vpc.git?ref=v2.0.0"
+}
+
+module "old" {
+  source = "git::https://github.com/org/tf-aws-vpc.git?ref=v1.0.0"
+}
+
+module "other" {
+  source = "git::https://github.com/org/other.git?ref=v1.0.0"
+}
+`
+	vendored := `module "role" {
+  # the role of the app
+  source = "./vendor/modules/tf-aws-iam/modules/role"
+  name   = "app"
+}
+
+module "vpc" {
+  source  = "../../vendor/modules/tf-aws-vpc"
+}
+
+module "old" {
+  source = "git::https://github.com/org/tf-aws-vpc.git?ref=v1.0.0"
+}
+
+module "other" {
+  source = "git::https://github.com/org/other.git?ref=v1.0.0"
+}
+`
+	createFile(t, "stacks/app/main.tf", remote)
+	config := map[string]module{
+		"tf-aws-iam": {Source: "https://github.com/org/tf-aws-iam.git", Version: "v1.0.0", Destinations: []string{"stacks/app"}},
+		"tf-aws-vpc": {Source: "git@github.com:org/tf-aws-vpc.git", Version: "v2.0.0"},
+	}
+
+	edits, err := sourceEdits(config, false)
+	assert.NoError(t, err)
+	assert.Len(t, edits, 2)
+	rewritten, err := rewriteSources(edits)
+	assert.NoError(t, err)
+	assert.Equal(t, vendored, rewritten["stacks/app/main.tf"])
+
+	// files keep their permissions
+	assert.NoError(t, os.Chmod("stacks/app/main.tf", 0600))
+	assert.NoError(t, writeRewrites(rewritten))
+	info, err := os.Stat("stacks/app/main.tf")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	edits, err = sourceEdits(config, true)
+	assert.NoError(t, err)
+	assert.Len(t, edits, 2)
+	rewritten, err = rewriteSources(edits)
+	assert.NoError(t, err)
+	assert.Equal(t, remote, rewritten["stacks/app/main.tf"])
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nl\nm\n"
+
+	diff, err := unifiedDiff("main.tf", before, after)
+	assert.NoError(t, err)
+	assert.Equal(t, `--- a/main.tf
++++ b/main.tf
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,5 +8,5 @@
+ h
+ i
+ j
+-k
+ l
++m
+`, diff)
+
+	diff, err = unifiedDiff("main.tf", before, before)
+	assert.NoError(t, err)
+	assert.Equal(t, "", diff)
+
+	// a missing newline at the end doesn't break the last line
+	diff, err = unifiedDiff("main.tf", "a\nb", "a\nc")
+	assert.NoError(t, err)
+	assert.Equal(t, "--- a/main.tf\n+++ b/main.tf\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n", diff)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// selectModules returns modules of config selected by --only, --exclude,
+// --tags and --destination flags. Destinations of selected modules are
+// narrowed down to the selected ones.
+func selectModules(config map[string]module) (map[string]module, error) {
+	only := splitList(opts.OnlyModules)
+	excluded := splitList(opts.ExcludeModules)
+	tags := splitList(opts.Tags)
+	destinations := splitList(opts.OnlyDestinations)
+
+	// unknown keys are most likely typos, which would silently select nothing
+	var unknown []string
+	for _, key := range append(append([]string{}, only...), excluded...) {
+		if _, ok := config[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown module(s) selected: %s", strings.Join(unknown, ", "))
+	}
+
+	selected := make(map[string]module)
+	for key, m := range config {
+		if len(only) > 0 && !contains(only, key) {
+			continue
+		}
+		if contains(excluded, key) {
+			continue
+		}
+		if len(tags) > 0 && !hasAny(m.Tags, tags) {
+			continue
+		}
+		if len(destinations) > 0 {
+			m.Destinations = selectDestinations(m.Destinations, destinations)
+			if m.Destinations == nil {
+				continue
+			}
+		}
+		selected[key] = m
+	}
+
+	return selected, nil
+}
+
+// selectDestinations returns destinations which are selected, or nil if there
+// are none. A module without destinations is installed into the root, which is
+// selected by ".". The first destination, the module is cloned into and linked
+// from, is kept whenever any other is selected.
+func selectDestinations(destinations []string, selected []string) []string {
+	if len(destinations) == 0 {
+		for _, s := range selected {
+			if filepath.Clean(s) == "." {
+				return []string{}
+			}
+		}
+		return nil
+	}
+
+	var matching []string
+	for _, d := range destinations {
+		for _, s := range selected {
+			if filepath.Clean(d) == filepath.Clean(s) {
+				matching = append(matching, d)
+				break
+			}
+		}
+	}
+	if len(matching) > 0 && matching[0] != destinations[0] {
+		matching = append([]string{destinations[0]}, matching...)
+	}
+
+	return matching
+}
+
+// unselectedModules returns names of modules of config left out of selected,
+// which must not be touched by cleaning or pruning
+func unselectedModules(selected map[string]module, config map[string]module) map[string]bool {
+	unselected := make(map[string]bool)
+	for key := range config {
+		if _, ok := selected[key]; !ok {
+			unselected[key] = true
+		}
+	}
+	return unselected
+}
+
+// splitList splits every comma separated value of a repeatable flag
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func hasAny(list []string, items []string) bool {
+	for _, item := range items {
+		if contains(list, item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectModules(t *testing.T) {
+	config := map[string]module{
+		"tf-aws-vpc":       {Tags: []string{"network"}},
+		"tf-aws-vpn":       {Destinations: []string{"networking"}, Tags: []string{"network", "vpn"}},
+		"tf-aws-iam":       {Destinations: []string{"iam"}},
+		"tf-aws-s3-bucket": {Destinations: []string{"networking", "onboarding", "some-other-stack"}},
+	}
+
+	for name, test := range map[string]struct {
+		only, exclude, tags, destinations []string
+		expected                          []string
+	}{
+		"everything":     {expected: []string{"tf-aws-iam", "tf-aws-s3-bucket", "tf-aws-vpc", "tf-aws-vpn"}},
+		"only":           {only: []string{"tf-aws-vpc,tf-aws-iam"}, expected: []string{"tf-aws-iam", "tf-aws-vpc"}},
+		"exclude":        {exclude: []string{"tf-aws-vpc", "tf-aws-iam"}, expected: []string{"tf-aws-s3-bucket", "tf-aws-vpn"}},
+		"tags":           {tags: []string{"vpn,iam"}, expected: []string{"tf-aws-vpn"}},
+		"destination":    {destinations: []string{"networking"}, expected: []string{"tf-aws-s3-bucket", "tf-aws-vpn"}},
+		"root":           {destinations: []string{"."}, expected: []string{"tf-aws-vpc"}},
+		"only and tags":  {only: []string{"tf-aws-vpc", "tf-aws-iam"}, tags: []string{"network"}, expected: []string{"tf-aws-vpc"}},
+		"nothing at all": {destinations: []string{"nowhere"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer restoreOpts()()
+			opts.OnlyModules = test.only
+			opts.ExcludeModules = test.exclude
+			opts.Tags = test.tags
+			opts.OnlyDestinations = test.destinations
+
+			selected, err := selectModules(config)
+			assert.NoError(t, err)
+			var keys []string
+			for key := range selected {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			assert.Equal(t, test.expected, keys)
+		})
+	}
+
+	defer restoreOpts()()
+	opts.OnlyDestinations = []string{"onboarding/", "some-other-stack"}
+	selected, err := selectModules(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"networking", "onboarding", "some-other-stack"}, selected["tf-aws-s3-bucket"].Destinations)
+
+	// selecting a destination the module is linked to keeps the one it's cloned into
+	opts.OnlyDestinations = []string{"some-other-stack"}
+	selected, err = selectModules(config)
+	assert.NoError(t, err)
+	cloneDestination, linkDestinations := moduleDestinations(selected["tf-aws-s3-bucket"])
+	original, _ := moduleDestinations(config["tf-aws-s3-bucket"])
+	assert.Equal(t, original, cloneDestination)
+	assert.Equal(t, []string{"some-other-stack"}, linkDestinations)
+
+	opts.OnlyDestinations = nil
+	opts.OnlyModules = []string{"tf-aws-vcp"}
+	_, err = selectModules(config)
+	assert.EqualError(t, err, "unknown module(s) selected: tf-aws-vcp")
+}
+
+func TestCleanDestinationsRespectsSelection(t *testing.T) {
+	defer restoreOpts()()
+	opts.ModulePath = "vendor/modules"
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	for _, dir := range []string{"networking/vendor/modules/tf-aws-vpn", "networking/vendor/modules/tf-aws-s3-bucket", "networking/vendor/modules/stale"} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+
+	config := map[string]module{
+		"tf-aws-vpn":       {Destinations: []string{"networking"}},
+		"tf-aws-s3-bucket": {Destinations: []string{"networking"}},
+	}
+	opts.OnlyModules = []string{"tf-aws-vpn"}
+	selected, err := selectModules(config)
+	assert.NoError(t, err)
+
+	cleanDestinations(selected, unselectedModules(selected, config))
+
+	entries, err := os.ReadDir(filepath.Join("networking", opts.ModulePath))
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "tf-aws-s3-bucket", entries[0].Name())
+	}
+}
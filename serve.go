@@ -0,0 +1,293 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// registryRepositoryPattern matches repositories named after the terraform
+// module convention terraform-<system>-<name>
+var registryRepositoryPattern = regexp.MustCompile(`^terraform-([a-z0-9]+)-(.+)$`)
+
+// invalidRegistryChars matches characters not allowed in registry addresses
+var invalidRegistryChars = regexp.MustCompile(`[^0-9A-Za-z_-]+`)
+
+// registrySystem is the system of modules whose repository doesn't follow the convention
+const registrySystem = "generic"
+
+type serveCommand struct {
+	Addr    string   `long:"addr" default:":8080" description:"Address to listen on"`
+	Dirs    []string `long:"dir" default:"." description:"Folder to serve vendored modules of, can be repeated"`
+	TLSCert string   `long:"tls_cert" description:"Certificate file to serve HTTPS with, terraform only uses registries over HTTPS"`
+	TLSKey  string   `long:"tls_key" description:"Key file of the certificate"`
+}
+
+// registryModule is a version of a vendored module served as a registry module
+type registryModule struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	System    string `json:"provider"`
+	Version   string `json:"version"`
+	Source    string `json:"source"`
+	// Dir is the folder the module is vendored in
+	Dir string `json:"-"`
+}
+
+// address returns the registry address of m without version
+func (m registryModule) address() string {
+	return path.Join(m.Namespace, m.Name, m.System)
+}
+
+// Execute serves every vendored module below the folders through the
+// terraform module registry protocol
+func (c *serveCommand) Execute(_ []string) error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("--tls_cert and --tls_key must be used together")
+	}
+
+	modules, err := indexVendoredModules(c.Dirs)
+	if err != nil {
+		return err
+	}
+	for _, m := range modules {
+		log.Infof("[*] Serving %s of %s as %s", m.Version, m.Source, m.address())
+	}
+	log.Infof("[*] Serving %d module version(s) on %s", len(modules), c.Addr)
+
+	server := &http.Server{Addr: c.Addr, Handler: newRegistryHandler(c.Dirs), ReadHeaderTimeout: 10 * time.Second}
+	if c.TLSCert != "" {
+		return server.ListenAndServeTLS(c.TLSCert, c.TLSKey)
+	}
+	return server.ListenAndServe()
+}
+
+// indexVendoredModules finds every module terrafile installed below dirs,
+// ordered by address and version. Versions which aren't semantic versions
+// can't be served. Symlinked modules are found where they are installed.
+func indexVendoredModules(dirs []string) ([]registryModule, error) {
+	var modules []registryModule
+	seen := make(map[string]string)
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if p != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			metadata, err := readMetadata(p)
+			if err != nil || metadata == nil {
+				return err
+			}
+
+			m, ok := registryModuleOf(metadata.Source, metadata.Version)
+			if !ok {
+				log.Warnf("[*] Not serving %s of %s in %s, registry versions must be semantic versions", metadata.Version, metadata.Source, p)
+				return filepath.SkipDir
+			}
+			m.Dir = p
+
+			id := m.address() + "/" + m.Version
+			switch source, ok := seen[id]; {
+			case !ok:
+				seen[id] = m.Source
+				modules = append(modules, m)
+			case source != m.Source:
+				log.Warnf("[*] Not serving %s of %s in %s, %s of %s is served as %s already", m.Version, m.Source, p, m.Version, source, m.address())
+			}
+
+			// modules installed for a nested Terrafile are served too
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find vendored modules in %s due to error: %s", dir, err)
+		}
+	}
+
+	sort.SliceStable(modules, func(i, j int) bool {
+		if modules[i].address() != modules[j].address() {
+			return modules[i].address() < modules[j].address()
+		}
+		return compareVersions(modules[i].Version, modules[j].Version) < 0
+	})
+	return modules, nil
+}
+
+// registryModuleOf returns the registry address a version of source is served as
+func registryModuleOf(source string, version string) (registryModule, bool) {
+	numbers, _ := splitVersion(version)
+	if len(numbers) != 3 {
+		return registryModule{}, false
+	}
+
+	// owner and name of the repository
+	repository := strings.TrimSuffix(strings.TrimRight(source, "/"), ".git")
+	segments := strings.FieldsFunc(repository, func(r rune) bool { return r == '/' || r == ':' })
+	name := segments[len(segments)-1]
+	namespace := "local"
+	if len(segments) > 1 {
+		namespace = segments[len(segments)-2]
+	}
+
+	system := registrySystem
+	if match := registryRepositoryPattern.FindStringSubmatch(name); match != nil {
+		system, name = match[1], match[2]
+	}
+
+	return registryModule{
+		Namespace: invalidRegistryChars.ReplaceAllString(namespace, "-"),
+		Name:      invalidRegistryChars.ReplaceAllString(name, "-"),
+		System:    system,
+		Version:   strings.TrimPrefix(version, "v"),
+		Source:    source,
+	}, true
+}
+
+// newRegistryHandler returns a handler serving vendored modules below dirs,
+// which are looked up again on every request
+func newRegistryHandler(dirs []string) http.Handler {
+	mux := http.NewServeMux()
+
+	// service discovery
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"modules.v1": "/v1/modules/"})
+	})
+
+	mux.HandleFunc("/v1/modules/", func(w http.ResponseWriter, r *http.Request) {
+		modules, err := indexVendoredModules(dirs)
+		if err != nil {
+			log.Errorf("failed to serve %s due to error: %s", r.URL.Path, err)
+			http.Error(w, "failed to find vendored modules", http.StatusInternalServerError)
+			return
+		}
+
+		segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/modules/"), "/"), "/")
+		switch {
+		case len(segments) == 1 && segments[0] == "":
+			// every module
+			writeJSON(w, map[string]interface{}{"modules": modules})
+		case len(segments) == 4 && segments[3] == "versions":
+			address := path.Join(segments[:3]...)
+			var versions []map[string]string
+			for _, m := range modules {
+				if m.address() == address {
+					versions = append(versions, map[string]string{"version": m.Version})
+				}
+			}
+			if versions == nil {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, map[string]interface{}{"modules": []interface{}{map[string]interface{}{"versions": versions}}})
+		case len(segments) == 5 && segments[4] == "download":
+			if _, ok := findRegistryModule(modules, path.Join(segments[:3]...), segments[3]); !ok {
+				http.NotFound(w, r)
+				return
+			}
+			// relative to the download URL
+			w.Header().Set("X-Terraform-Get", fmt.Sprintf("/archive/%s/%s.tar.gz", path.Join(segments[:3]...), segments[3]))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	mux.HandleFunc("/archive/", func(w http.ResponseWriter, r *http.Request) {
+		modules, err := indexVendoredModules(dirs)
+		if err != nil {
+			log.Errorf("failed to serve %s due to error: %s", r.URL.Path, err)
+			http.Error(w, "failed to find vendored modules", http.StatusInternalServerError)
+			return
+		}
+
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/archive/"), "/")
+		if len(segments) != 4 || !strings.HasSuffix(segments[3], ".tar.gz") {
+			http.NotFound(w, r)
+			return
+		}
+		m, ok := findRegistryModule(modules, path.Join(segments[:3]...), strings.TrimSuffix(segments[3], ".tar.gz"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		archive := newArchiveWriter(w)
+		err = archive.addTree("", m.Dir, func(rel string) bool { return rel == metadataFile })
+		if err == nil {
+			err = archive.Close()
+		}
+		if err != nil {
+			// the response has started already
+			log.Errorf("failed to write archive of %s due to error: %s", m.Dir, err)
+		}
+	})
+
+	return logRequests(onlyReads(mux))
+}
+
+func findRegistryModule(modules []registryModule, address string, version string) (registryModule, bool) {
+	for _, m := range modules {
+		if m.address() == address && m.Version == version {
+			return m, true
+		}
+	}
+	return registryModule{}, false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("failed to write response due to error: %s", err)
+	}
+}
+
+// onlyReads rejects every request which isn't a GET or HEAD
+func onlyReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("[*] %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryModuleOf(t *testing.T) {
+	m, ok := registryModuleOf("git@github.com:terraform-aws-modules/terraform-aws-vpc", "v1.46.0")
+	assert.True(t, ok)
+	assert.Equal(t, "terraform-aws-modules/vpc/aws", m.address())
+	assert.Equal(t, "1.46.0", m.Version)
+
+	m, ok = registryModuleOf("https://github.com/org/tf.modules.git", "2.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, "org/tf-modules/generic", m.address())
+
+	_, ok = registryModuleOf("https://github.com/org/network.git", "master")
+	assert.False(t, ok)
+}
+
+func TestIndexVendoredModules(t *testing.T) {
+	dir := createVendoredModules(t)
+
+	modules, err := indexVendoredModules([]string{dir})
+	assert.NoError(t, err)
+
+	var ids []string
+	for _, m := range modules {
+		ids = append(ids, m.address()+" "+m.Version)
+	}
+	// the branch can't be served, the copy in another stack is served once
+	assert.Equal(t, []string{"org/network/generic 1.2.0", "org/network/generic 1.10.0", "org/vpc/aws 1.0.0"}, ids)
+}
+
+func TestRegistryHandler(t *testing.T) {
+	dir := createVendoredModules(t)
+	server := httptest.NewServer(newRegistryHandler([]string{dir}))
+	defer server.Close()
+
+	var discovery map[string]string
+	getJSON(t, server.URL+"/.well-known/terraform.json", &discovery)
+	assert.Equal(t, map[string]string{"modules.v1": "/v1/modules/"}, discovery)
+
+	var versions struct {
+		Modules []struct {
+			Versions []struct {
+				Version string `json:"version"`
+			} `json:"versions"`
+		} `json:"modules"`
+	}
+	getJSON(t, server.URL+"/v1/modules/org/network/generic/versions", &versions)
+	assert.Len(t, versions.Modules, 1)
+	assert.Len(t, versions.Modules[0].Versions, 2)
+	assert.Equal(t, "1.10.0", versions.Modules[0].Versions[1].Version)
+
+	resp, err := http.Get(server.URL + "/v1/modules/org/vpc/aws/versions")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(server.URL + "/v1/modules/org/missing/aws/versions")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/v1/modules/org/vpc/aws/1.0.0/download")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "/archive/org/vpc/aws/1.0.0.tar.gz", resp.Header.Get("X-Terraform-Get"))
+
+	resp, err = http.Get(server.URL + "/v1/modules/org/vpc/aws/9.9.9/download")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/v1/modules/org/vpc/aws/versions", "application/json", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/archive/org/vpc/aws/1.0.0.tar.gz")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	gz, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		files[header.Name] = string(data)
+	}
+	assert.Equal(t, map[string]string{
+		"main.tf":            "# vpc\n",
+		"modules/":           "",
+		"modules/subnets.tf": "# subnets\n",
+	}, files)
+}
+
+// createVendoredModules creates a project with modules vendored into two stacks
+func createVendoredModules(t *testing.T) string {
+	dir := t.TempDir()
+	for _, m := range []struct {
+		dir     string
+		source  string
+		version string
+	}{
+		{"stacks/app/vendor/modules/vpc", "https://github.com/org/terraform-aws-vpc.git", "v1.0.0"},
+		{"stacks/app/vendor/modules/network", "https://github.com/org/network.git", "v1.10.0"},
+		{"stacks/db/vendor/modules/network", "https://github.com/org/network.git", "v1.10.0"},
+		{"stacks/db/vendor/modules/network-old", "https://github.com/org/network.git", "1.2.0"},
+		{"stacks/db/vendor/modules/network-main", "https://github.com/org/network.git", "main"},
+	} {
+		p := filepath.Join(dir, m.dir)
+		assert.NoError(t, os.MkdirAll(filepath.Join(p, "modules"), os.ModePerm))
+		assert.NoError(t, os.MkdirAll(filepath.Join(p, ".git"), os.ModePerm))
+		createFile(t, filepath.Join(p, ".git", "HEAD"), "ref: refs/heads/main\n")
+		createFile(t, filepath.Join(p, "main.tf"), "# "+filepath.Base(m.dir)+"\n")
+		createFile(t, filepath.Join(p, "modules", "subnets.tf"), "# subnets\n")
+		assert.NoError(t, writeMetadata(p, moduleMetadata{Source: m.source, Version: m.version}))
+	}
+	return dir
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
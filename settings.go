@@ -0,0 +1,184 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settingsKey is the reserved top-level key of the Terrafile holding defaults
+// for the whole file, it is never decoded as a module
+const settingsKey = "terrafile"
+
+// settingsFields lists every field the settings section may have
+var settingsFields = []string{"source_prefix", "module_path", "link_mode", "concurrency", "retries", "destinations", "include", "catalog", "profiles", "auto_destinations", "mirrors"}
+
+// linkModes lists the ways a module can be made available in its extra destinations
+var linkModes = []string{"symlink", "relative", "copy"}
+
+// terrafileSettings are defaults set in the settings section of the Terrafile
+type terrafileSettings struct {
+	SourcePrefix string                              `yaml:"source_prefix"`
+	ModulePath   string                              `yaml:"module_path"`
+	LinkMode     string                              `yaml:"link_mode"`
+	Concurrency  *int                                `yaml:"concurrency"`
+	Retries      *int                                `yaml:"retries"`
+	Destinations []string                            `yaml:"destinations"`
+	Include      []fileReference                     `yaml:"include"`
+	Catalog      *fileReference                      `yaml:"catalog"`
+	Profiles     map[string]map[string]profileModule `yaml:"profiles"`
+	// AutoDestinations discovers destinations of modules from terraform files
+	AutoDestinations bool `yaml:"auto_destinations"`
+	// Mirrors rewrite sources before anything is fetched, after those of the user configuration
+	Mirrors []mirrorRule `yaml:"mirrors"`
+}
+
+// applyModuleDefaults applies defaults of the settings section to modules of
+// config, which must all come from the same file as the section
+func applyModuleDefaults(config map[string]module, settings terrafileSettings) {
+	for key, m := range config {
+		if settings.SourcePrefix != "" && relativeSource(m.Source) {
+			m.Source = prefixSource(settings.SourcePrefix, m.Source)
+		}
+		// an explicitly empty list keeps the module in the root
+		if m.Destinations == nil && len(settings.Destinations) > 0 {
+			m.Destinations = append([]string{}, settings.Destinations...)
+		}
+		config[key] = m
+	}
+}
+
+// applyOptionDefaults applies defaults of the settings section to options
+// which weren't set on the command line
+func applyOptionDefaults(settings terrafileSettings) {
+	if settings.ModulePath != "" && !flagSet("module_path") {
+		opts.ModulePath = settings.ModulePath
+	}
+	if settings.LinkMode != "" && !flagSet("link_mode") {
+		opts.LinkMode = settings.LinkMode
+	}
+	if settings.Concurrency != nil && !flagSet("concurrency") {
+		opts.Concurrency = *settings.Concurrency
+	}
+	if settings.Retries != nil && !flagSet("retries") {
+		opts.Retries = *settings.Retries
+	}
+}
+
+// relativeSource reports whether source is a bare repository name, which is
+// appended to the source prefix, rather than a URL or a path
+func relativeSource(source string) bool {
+	return source != "" &&
+		!strings.Contains(source, ":") &&
+		!strings.HasPrefix(source, "/") &&
+		!strings.HasPrefix(source, ".") &&
+		!strings.HasPrefix(source, "~")
+}
+
+// prefixSource joins prefix and a relative source, adding a slash unless the
+// prefix already ends with a separator, e.g. `git@github.com:org/` or `git@github.com:`
+func prefixSource(prefix string, source string) string {
+	if strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, ":") {
+		return prefix + source
+	}
+	return prefix + "/" + source
+}
+
+// takeSection removes the pair of key from mapping node and returns its value,
+// or nil if there is no such key
+func takeSection(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateSettings(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "%q section must be a mapping with %s fields", settingsKey, strings.Join(settingsFields, ", "))
+		return
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fieldNode, valueNode := node.Content[i], node.Content[i+1]
+		field := fieldNode.Value
+
+		if _, ok := fields[field]; ok {
+			v.errorf(fieldNode, "duplicate field %q in %q section", field, settingsKey)
+			continue
+		}
+		fields[field] = valueNode
+
+		if !contains(settingsFields, field) {
+			message := fmt.Sprintf("unknown field %q in %q section", field, settingsKey)
+			if suggestion := closest(field, settingsFields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.errorf(fieldNode, "%s", message)
+		}
+	}
+
+	for _, field := range []string{"source_prefix", "module_path", "link_mode"} {
+		valueNode, ok := fields[field]
+		switch {
+		case !ok:
+		case valueNode.Kind != yaml.ScalarNode:
+			v.errorf(valueNode, "field %q of %q section must be a string", field, settingsKey)
+		case strings.TrimSpace(valueNode.Value) == "":
+			v.errorf(valueNode, "field %q of %q section must not be empty", field, settingsKey)
+		}
+	}
+	if valueNode, ok := fields["link_mode"]; ok && valueNode.Kind == yaml.ScalarNode && valueNode.Value != "" && !contains(linkModes, valueNode.Value) {
+		v.errorf(valueNode, "invalid link_mode %q, expected one of %s", valueNode.Value, strings.Join(linkModes, ", "))
+	}
+
+	for _, field := range []string{"concurrency", "retries"} {
+		if valueNode, ok := fields[field]; ok {
+			if n, err := strconv.Atoi(valueNode.Value); valueNode.Kind != yaml.ScalarNode || err != nil || n < 0 {
+				v.errorf(valueNode, "field %q of %q section must be a non-negative number", field, settingsKey)
+			}
+		}
+	}
+
+	if valueNode, ok := fields["destinations"]; ok {
+		v.validateDestinations(fmt.Sprintf("%q section", settingsKey), valueNode)
+	}
+	if valueNode, ok := fields["include"]; ok {
+		v.validateIncludes(valueNode)
+	}
+	if valueNode, ok := fields["catalog"]; ok {
+		v.validateReference("catalog", valueNode)
+	}
+	if valueNode, ok := fields["profiles"]; ok {
+		v.validateProfiles(valueNode)
+	}
+	if valueNode, ok := fields["mirrors"]; ok {
+		v.validateMirrors(fmt.Sprintf("%q section", settingsKey), valueNode)
+	}
+	if valueNode, ok := fields["auto_destinations"]; ok && (valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!bool") {
+		v.errorf(valueNode, "field \"auto_destinations\" of %q section must be true or false", settingsKey)
+	}
+}
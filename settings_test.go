@@ -0,0 +1,121 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTerrafileSettings(t *testing.T) {
+	defer restoreOpts()()
+	defer func(rules []mirrorRule) { mirrorRules = rules }(mirrorRules)
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	opts.TerrafilePath = "Terrafile"
+	createFile(t, "Terrafile", `terrafile:
+  source_prefix: "git@github.com:terraform-aws-modules/"
+  module_path: "modules"
+  link_mode: copy
+  concurrency: 4
+  retries: 2
+  destinations:
+    - networking
+  include:
+    - platform.Terrafile
+tf-aws-vpc:
+  source:  "terraform-aws-vpc"
+  version: "v1.46.0"
+tf-aws-iam:
+  source:  "https://github.com/terraform-aws-modules/terraform-aws-iam"
+  version: "v1.0.0"
+  destinations: []
+`)
+	// defaults of a file apply to its own modules only
+	createFile(t, "platform.Terrafile", `terrafile:
+  source_prefix: "git@github.com:platform/"
+tf-aws-debug:
+  source:  "tf-aws-debug"
+  version: "v1.0.0"
+`)
+
+	config, err := loadTerrafile()
+	assert.NoError(t, err)
+	assert.NotContains(t, config, settingsKey)
+	assert.Equal(t, map[string]module{
+		"tf-aws-vpc": {
+			Source:       "git@github.com:terraform-aws-modules/terraform-aws-vpc",
+			Version:      "v1.46.0",
+			Destinations: []string{"networking"},
+			Origin:       "Terrafile",
+		},
+		"tf-aws-iam": {
+			Source:       "https://github.com/terraform-aws-modules/terraform-aws-iam",
+			Version:      "v1.0.0",
+			Destinations: []string{},
+			Origin:       "Terrafile",
+		},
+		"tf-aws-debug": {
+			Source:  "git@github.com:platform/tf-aws-debug",
+			Version: "v1.0.0",
+			Origin:  "platform.Terrafile",
+		},
+	}, config)
+
+	assert.Equal(t, "modules", opts.ModulePath)
+	assert.Equal(t, "copy", opts.LinkMode)
+	assert.Equal(t, 4, opts.Concurrency)
+	assert.Equal(t, 2, opts.Retries)
+}
+
+func TestParseTerrafileSettingsErrors(t *testing.T) {
+	_, _, errs := parseTerrafile("Terrafile", []byte(`terrafile:
+  source_prefx: "git@github.com:terraform-aws-modules/"
+  link_mode: hardlink
+  retries: -1
+  destinations: networking
+`))
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		`Terrafile:2:3: unknown field "source_prefx" in "terrafile" section, did you mean "source_prefix"?`,
+		`Terrafile:3:14: invalid link_mode "hardlink", expected one of symlink, relative, copy`,
+		`Terrafile:4:12: field "retries" of "terrafile" section must be a non-negative number`,
+		`Terrafile:5:17: field "destinations" of "terrafile" section must be a list of paths`,
+	}, messages)
+}
+
+func TestPrefixSource(t *testing.T) {
+	for source, expected := range map[string]string{
+		"terraform-aws-vpc":                        "git@github.com:org/terraform-aws-vpc",
+		"git@gitlab.com:org/terraform-aws-vpc":     "git@gitlab.com:org/terraform-aws-vpc",
+		"https://gitlab.com/org/terraform-aws-vpc": "https://gitlab.com/org/terraform-aws-vpc",
+		"./modules/vpc":                            "./modules/vpc",
+		"/srv/git/vpc":                             "/srv/git/vpc",
+	} {
+		actual := source
+		if relativeSource(source) {
+			actual = prefixSource("git@github.com:org", source)
+		}
+		assert.Equal(t, expected, actual, source)
+	}
+	assert.Equal(t, "git@github.com:vpc", prefixSource("git@github.com:", "vpc"))
+}
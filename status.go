@@ -0,0 +1,259 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// States of an installed module
+const (
+	stateOK         = "ok"
+	stateMissing    = "missing"
+	stateUnmanaged  = "unmanaged"
+	stateOutdated   = "outdated"
+	stateModified   = "modified"
+	stateNotLinked  = "not linked"
+	stateOverridden = "overridden"
+)
+
+// moduleStatus is the state of a module at one of its install locations
+type moduleStatus struct {
+	Key      string
+	Path     string
+	State    string
+	Details  []string
+	Metadata *moduleMetadata
+}
+
+type statusCommand struct{}
+
+// Execute prints state of every module of the Terrafile at each of its locations
+func (c *statusCommand) Execute(_ []string) error {
+	config, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	config, err = selectModules(config)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tPATH\tVERSION\tCOMMIT\tSTATE")
+	for _, status := range moduleStatuses(config) {
+		version, commit := "-", "-"
+		if status.Metadata != nil {
+			version, commit = status.Metadata.Version, shortCommit(status.Metadata.Commit)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Key, status.Path, version, commit, status.State)
+		for _, detail := range status.Details {
+			fmt.Fprintf(w, "\t    %s\t\t\t\n", detail)
+		}
+	}
+
+	return w.Flush()
+}
+
+type verifyCommand struct{}
+
+// Execute fails unless every module of the Terrafile is installed unmodified
+// at the version from the Terrafile
+func (c *verifyCommand) Execute(_ []string) error {
+	config, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	config, err = selectModules(config)
+	if err != nil {
+		return err
+	}
+
+	for _, override := range activeOverrides(config) {
+		log.Errorf("[*] Override is active, %s", override)
+	}
+
+	failed := 0
+	for _, status := range moduleStatuses(config) {
+		if status.State == stateOK {
+			log.Infof("[*] %s is %s at %s of %s (%s)", status.Path, status.State, status.Metadata.Version, status.Metadata.Source, shortCommit(status.Metadata.Commit))
+			continue
+		}
+
+		failed++
+		log.Errorf("[*] %s is %s", status.Path, status.State)
+		for _, detail := range status.Details {
+			log.Errorf("[*]     %s", detail)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verification failed for %d module location(s)", failed)
+	}
+
+	return nil
+}
+
+// moduleStatuses returns state of every module at each of its locations,
+// ordered by module name
+func moduleStatuses(config map[string]module) []moduleStatus {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var statuses []moduleStatus
+	for _, key := range keys {
+		m := config[key]
+		cloneDestination, linkDestinations := moduleDestinations(m)
+		moduleDir := filepath.Join(cloneDestination, key)
+
+		status := installedStatus(key, m, moduleDir)
+		if m.LocalPath != "" {
+			status = localStatus(key, m, moduleDir)
+		}
+		statuses = append(statuses, overriddenStatus(m, status))
+
+		for _, d := range linkDestinations {
+			path := filepath.Join(d, opts.ModulePath, key)
+			// copies are checked just like the module they were copied from
+			if opts.LinkMode == "copy" && m.LocalPath == "" {
+				statuses = append(statuses, overriddenStatus(m, installedStatus(key, m, path)))
+				continue
+			}
+			statuses = append(statuses, overriddenStatus(m, linkStatus(status, path, moduleDir)))
+		}
+	}
+
+	return statuses
+}
+
+// installedStatus returns state of module m installed in moduleDir
+func installedStatus(key string, m module, moduleDir string) moduleStatus {
+	status := moduleStatus{Key: key, Path: moduleDir}
+
+	if _, err := os.Stat(moduleDir); err != nil {
+		status.State = stateMissing
+		return status
+	}
+
+	metadata, err := readMetadata(moduleDir)
+	if err != nil || metadata == nil {
+		status.State = stateUnmanaged
+		if err != nil {
+			status.Details = []string{err.Error()}
+		}
+		return status
+	}
+	status.Metadata = metadata
+
+	if metadata.Source != m.Source || metadata.Version != m.Version {
+		status.State = stateOutdated
+		status.Details = []string{fmt.Sprintf("installed %s of %s, Terrafile wants %s of %s", metadata.Version, metadata.Source, m.Version, m.Source)}
+		return status
+	}
+
+	changes, err := localModifications(moduleDir)
+	if err != nil {
+		status.State = stateModified
+		status.Details = []string{err.Error()}
+		return status
+	}
+	if len(changes) > 0 {
+		status.State = stateModified
+		status.Details = changes
+		return status
+	}
+
+	status.State = stateOK
+	return status
+}
+
+// localStatus returns state of module m linked at moduleDir from its local checkout
+func localStatus(key string, m module, moduleDir string) moduleStatus {
+	status := moduleStatus{Key: key, Path: moduleDir, State: stateOK}
+
+	target, err := resolveLink(moduleDir)
+	if err != nil {
+		status.State = stateMissing
+		return status
+	}
+	if expected, err := resolveLink(m.LocalPath); err != nil || expected != target {
+		status.State = stateNotLinked
+		status.Details = []string{fmt.Sprintf("expected a link to local checkout %s", m.LocalPath)}
+	}
+
+	return status
+}
+
+// overriddenStatus marks status of an overridden module, which is never ok
+func overriddenStatus(m module, status moduleStatus) moduleStatus {
+	if !m.Overridden || status.State != stateOK {
+		return status
+	}
+
+	status.State = stateOverridden
+	if m.LocalPath != "" {
+		status.Details = []string{fmt.Sprintf("linked to local checkout %s by %s", m.LocalPath, m.Origin)}
+	} else {
+		status.Details = []string{fmt.Sprintf("redirected to %s of %s by %s", m.Version, m.Source, m.Origin)}
+	}
+	return status
+}
+
+// linkStatus returns state of the link at path to module installed in moduleDir
+func linkStatus(installed moduleStatus, path string, moduleDir string) moduleStatus {
+	status := installed
+	status.Path = path
+
+	target, err := resolveLink(path)
+	if err != nil {
+		status.State = stateMissing
+		status.Details = nil
+		return status
+	}
+	if expected, err := resolveLink(moduleDir); err != nil || expected != target {
+		status.State = stateNotLinked
+		status.Details = []string{fmt.Sprintf("expected a link to %s", moduleDir)}
+	}
+
+	return status
+}
+
+// resolveLink returns absolute path of path with all symlinks resolved
+func resolveLink(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// shortCommit abbreviates commit hash for display
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
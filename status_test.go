@@ -0,0 +1,68 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleStatuses(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	source := createGitRepository(t, "v1.0.0", "v1.1.0")
+	config := map[string]module{
+		"tf-aws-vpc": {Source: source, Version: "v1.0.0", Destinations: []string{"networking", "onboarding"}},
+	}
+
+	statuses := moduleStatuses(config)
+	assert.Equal(t, []string{stateMissing, stateMissing}, states(statuses))
+
+	assert.NoError(t, os.MkdirAll("networking/vendor/modules", os.ModePerm))
+	assert.NoError(t, installModule("tf-aws-vpc", config["tf-aws-vpc"], "networking/vendor/modules"))
+	assert.NoError(t, os.MkdirAll("onboarding/vendor/modules", os.ModePerm))
+	abs, err := filepath.Abs("networking/vendor/modules/tf-aws-vpc")
+	assert.NoError(t, err)
+	assert.NoError(t, os.Symlink(abs, "onboarding/vendor/modules/tf-aws-vpc"))
+
+	statuses = moduleStatuses(config)
+	assert.Equal(t, []string{stateOK, stateOK}, states(statuses))
+	assert.Equal(t, "onboarding/vendor/modules/tf-aws-vpc", statuses[1].Path)
+
+	createFile(t, "networking/vendor/modules/tf-aws-vpc/main.tf", "# hotfix\n")
+	statuses = moduleStatuses(config)
+	assert.Equal(t, []string{stateModified, stateModified}, states(statuses))
+	assert.Equal(t, []string{" M main.tf"}, statuses[0].Details)
+
+	config["tf-aws-vpc"] = module{Source: source, Version: "v1.1.0", Destinations: []string{"networking", "onboarding"}}
+	statuses = moduleStatuses(config)
+	assert.Equal(t, []string{stateOutdated, stateOutdated}, states(statuses))
+}
+
+func states(statuses []moduleStatus) []string {
+	var states []string
+	for _, status := range statuses {
+		states = append(states, status.State)
+	}
+	return states
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// moduleDependency is a remote module used by terraform files of a vendored
+// module, vendored next to it as module Key
+type moduleDependency struct {
+	Key     string `json:"key"`
+	Source  string `json:"source"`
+	Version string `json:"version"`
+}
+
+// dependencyKey returns the name a remote module used by a vendored module is
+// vendored as, the version is part of it as modules may use different ones
+func dependencyKey(source remoteSource) string {
+	return invalidKeyChars.ReplaceAllString(repositoryName(source.Source)+"-"+source.Version, "-")
+}
+
+// vendorSources points remote module sources of terraform files of module m,
+// fetched into dir, to copies vendored next to it and returns the modules
+// they use. Sources into the repository of m itself point into m.
+func vendorSources(dir string, m module) ([]moduleDependency, error) {
+	var dependencies []moduleDependency
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if p != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".tf" || !entry.Type().IsRegular() {
+			return nil
+		}
+
+		file, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var edits []sourceEdit
+		for _, block := range scanModuleBlocks(string(data)) {
+			if block.Source == "" || strings.HasPrefix(block.Source, "./") || strings.HasPrefix(block.Source, "../") {
+				continue
+			}
+			source, err := parseModuleSource(block.Source, block.Version)
+			if err != nil {
+				log.Warnf("[*] %s:%d of module %s: not vendoring module %q, terraform will fetch it, %s", filepath.ToSlash(file), block.Line, m.Source, block.Name, err)
+				continue
+			}
+
+			// relative to the root of the module
+			target := path.Join("..", dependencyKey(source), source.Subdir)
+			if catalogKey(source.Source) == catalogKey(m.Source) && source.Version == m.Version {
+				target = path.Join(".", source.Subdir)
+			} else if key := dependencyKey(source); !seen[key] {
+				seen[key] = true
+				dependencies = append(dependencies, moduleDependency{Key: key, Source: source.Source, Version: source.Version})
+			}
+
+			rel, err := filepath.Rel(filepath.Dir(file), filepath.FromSlash(target))
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !strings.HasPrefix(rel, "../") {
+				rel = "./" + strings.TrimPrefix(rel, ".")
+			}
+			edits = append(edits, sourceEdit{File: p, Block: block, Source: rel})
+		}
+		if len(edits) == 0 {
+			return nil
+		}
+
+		rewritten, err := rewriteSources(edits)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(p, []byte(rewritten[p]), info.Mode().Perm())
+	})
+	sort.Slice(dependencies, func(i, j int) bool { return dependencies[i].Key < dependencies[j].Key })
+
+	return dependencies, err
+}
+
+// installDependencies installs the modules used by installed modules of
+// config next to them, and in turn the modules those use. It returns the
+// installed dependencies. A dependency named like a module of allModules
+// must be that very module installed into the same destinations.
+func installDependencies(config map[string]module, allModules map[string]module, install func(map[string]module)) (map[string]module, error) {
+	derived := make(map[string]module)
+
+	for pending := config; len(pending) > 0; {
+		next := make(map[string]module)
+		for _, key := range sortedKeys(pending) {
+			m := pending[key]
+			if m.LocalPath != "" {
+				// local checkouts are never rewritten
+				continue
+			}
+
+			cloneDestination, _ := moduleDestinations(m)
+			metadata, err := readMetadata(filepath.Join(cloneDestination, key))
+			if err != nil {
+				return nil, err
+			}
+			if metadata == nil {
+				return nil, fmt.Errorf("module %s has no metadata in %s", key, filepath.Join(cloneDestination, key))
+			}
+
+			for _, dependency := range metadata.Dependencies {
+				if existing, ok := allModules[dependency.Key]; ok {
+					if !sameDependency(existing, dependency) {
+						return nil, fmt.Errorf("module %s uses %s of %s, which conflicts with module %s of %s", key, dependency.Version, dependency.Source, dependency.Key, opts.TerrafilePath)
+					}
+					if missing := missingDestinations(existing, m); len(missing) > 0 {
+						return nil, fmt.Errorf("module %s uses module %s, which is not installed into %s", key, dependency.Key, strings.Join(missing, ", "))
+					}
+					continue
+				}
+
+				d, ok := next[dependency.Key]
+				if !ok {
+					d, ok = derived[dependency.Key]
+				}
+				changed := !ok
+				switch {
+				case !ok:
+					d = module{Source: dependency.Source, Version: dependency.Version, Destinations: destinationsOf(m)}
+				case !sameDependency(d, dependency):
+					return nil, fmt.Errorf("module %s uses %s of %s, which conflicts with %s of %s used by %s", key, dependency.Version, dependency.Source, d.Version, d.Source, strings.Join(d.DerivedFrom, ", "))
+				default:
+					// installed again to link it into new destinations
+					if missing := missingDestinations(d, m); len(missing) > 0 {
+						d.Destinations = append(append([]string(nil), d.Destinations...), missing...)
+						changed = true
+					}
+				}
+				if !contains(d.DerivedFrom, key) {
+					d.DerivedFrom = append(append([]string(nil), d.DerivedFrom...), key)
+					sort.Strings(d.DerivedFrom)
+				}
+
+				if _, ok := next[dependency.Key]; ok || changed {
+					next[dependency.Key] = d
+				} else {
+					derived[dependency.Key] = d
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+
+		if errs := checkSources(next); len(errs) > 0 {
+			for _, err := range errs[1:] {
+				log.Error(err)
+			}
+			return nil, errs[0]
+		}
+		for _, key := range sortedKeys(next) {
+			log.Infof("[*] Vendoring %s of %s used by %s", next[key].Version, next[key].Source, strings.Join(next[key].DerivedFrom, ", "))
+		}
+		install(next)
+
+		for key, m := range next {
+			derived[key] = m
+		}
+		pending = next
+	}
+
+	return derived, nil
+}
+
+// sameDependency reports whether m is the module dependency refers to
+func sameDependency(m module, dependency moduleDependency) bool {
+	return catalogKey(m.Source) == catalogKey(dependency.Source) && m.Version == dependency.Version
+}
+
+// destinationsOf returns destinations of m, the root if it has none
+func destinationsOf(m module) []string {
+	if len(m.Destinations) == 0 {
+		return []string{"."}
+	}
+	return append([]string(nil), m.Destinations...)
+}
+
+// missingDestinations returns destinations of user which dependency isn't
+// installed into
+func missingDestinations(dependency module, user module) []string {
+	var missing []string
+	for _, d := range destinationsOf(user) {
+		if !installedInto(dependency, d) {
+			missing = append(missing, d)
+		}
+	}
+	return missing
+}
+
+// keepDependencies adds the modules kept modules of module path use to keep
+func keepDependencies(keep map[string]bool) {
+	pending := make([]string, 0, len(keep))
+	for key := range keep {
+		pending = append(pending, key)
+	}
+
+	for len(pending) > 0 {
+		key := pending[0]
+		pending = pending[1:]
+
+		metadata, err := readMetadata(filepath.Join(opts.ModulePath, key))
+		if err != nil || metadata == nil {
+			continue
+		}
+		for _, dependency := range metadata.Dependencies {
+			if !keep[dependency.Key] {
+				keep[dependency.Key] = true
+				pending = append(pending, dependency.Key)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVendorSources(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "modules/role"), os.ModePerm))
+	createFile(t, filepath.Join(dir, "main.tf"), `module "role" {
+  source = "./modules/role"
+}
+
+module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws"
+  version = "5.1.0"
+}
+
+module "other" {
+  source = "terraform-aws-modules/vpc/aws"
+  version = "~> 5.0"
+}
+`)
+	createFile(t, filepath.Join(dir, "modules/role/main.tf"), `module "policy" {
+  source = "git::https://github.com/org/tf-aws-iam.git//modules/policy?ref=v1.0.0"
+}
+
+module "label" {
+  source = "git::https://github.com/org/tf-label.git?ref=v0.1.0"
+}
+
+module "vpc" {
+  source = "git::https://github.com/terraform-aws-modules/terraform-aws-vpc?ref=v5.1.0"
+}
+`)
+
+	dependencies, err := vendorSources(dir, module{Source: "https://github.com/org/tf-aws-iam.git", Version: "v1.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []moduleDependency{
+		{Key: "terraform-aws-vpc-v5.1.0", Source: "https://github.com/terraform-aws-modules/terraform-aws-vpc.git", Version: "v5.1.0"},
+		{Key: "tf-label-v0.1.0", Source: "https://github.com/org/tf-label.git", Version: "v0.1.0"},
+	}, dependencies)
+
+	contents, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, `module "role" {
+  source = "./modules/role"
+}
+
+module "vpc" {
+  source  = "../terraform-aws-vpc-v5.1.0"
+}
+
+module "other" {
+  source = "terraform-aws-modules/vpc/aws"
+  version = "~> 5.0"
+}
+`, string(contents))
+
+	contents, err = os.ReadFile(filepath.Join(dir, "modules/role/main.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, `module "policy" {
+  source = "../policy"
+}
+
+module "label" {
+  source = "../../../tf-label-v0.1.0"
+}
+
+module "vpc" {
+  source = "../../../terraform-aws-vpc-v5.1.0"
+}
+`, string(contents))
+}
+
+func TestInstallDependencies(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.GitProtocols = []string{"https"}
+	back := chdir(t, t.TempDir())
+	defer back()
+
+	// a uses b and c, b uses c
+	dependencies := map[string][]moduleDependency{
+		"a":        {{Key: "b-v1.0.0", Source: "https://example.com/b.git", Version: "v1.0.0"}, {Key: "c-v1.0.0", Source: "https://example.com/c.git", Version: "v1.0.0"}},
+		"b-v1.0.0": {{Key: "c-v1.0.0", Source: "https://example.com/c.git", Version: "v1.0.0"}},
+		"d":        {{Key: "b-v1.0.0", Source: "https://example.com/b.git", Version: "v1.0.0"}},
+	}
+	var rounds [][]string
+	install := func(modules map[string]module) {
+		rounds = append(rounds, sortedKeys(modules))
+		for key, m := range modules {
+			cloneDestination, _ := moduleDestinations(m)
+			assert.NoError(t, os.MkdirAll(filepath.Join(cloneDestination, key), os.ModePerm))
+			assert.NoError(t, writeMetadata(filepath.Join(cloneDestination, key), moduleMetadata{Source: m.Source, Version: m.Version, Dependencies: dependencies[key]}))
+		}
+	}
+
+	config := map[string]module{
+		"a": {Source: "https://example.com/a.git", Version: "v1.0.0", Destinations: []string{"stacks/app"}},
+		"d": {Source: "https://example.com/d.git", Version: "v1.0.0", Destinations: []string{"stacks/db"}},
+	}
+	install(config)
+	rounds = nil
+
+	derived, err := installDependencies(config, config, install)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"b-v1.0.0", "c-v1.0.0"}, {"c-v1.0.0"}}, rounds)
+	assert.Equal(t, map[string]module{
+		"b-v1.0.0": {Source: "https://example.com/b.git", Version: "v1.0.0", Destinations: []string{"stacks/app", "stacks/db"}, DerivedFrom: []string{"a", "d"}},
+		"c-v1.0.0": {Source: "https://example.com/c.git", Version: "v1.0.0", Destinations: []string{"stacks/app", "stacks/db"}, DerivedFrom: []string{"a", "b-v1.0.0"}},
+	}, derived)
+
+	t.Run("conflict", func(t *testing.T) {
+		allModules := map[string]module{
+			"a":        config["a"],
+			"b-v1.0.0": {Source: "https://example.com/other.git", Version: "v1.0.0"},
+		}
+		_, err := installDependencies(map[string]module{"a": config["a"]}, allModules, install)
+		assert.EqualError(t, err, "module a uses v1.0.0 of https://example.com/b.git, which conflicts with module b-v1.0.0 of ")
+	})
+}
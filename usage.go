@@ -0,0 +1,138 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// doctorCommand groups commands diagnosing the project
+type doctorCommand struct{}
+
+type doctorUsageCommand struct {
+	Strict bool `long:"strict" description:"Exit with an error if any unused or missing module is found"`
+}
+
+// usageFinding is a module vendored where nothing uses it, or used where it isn't vendored
+type usageFinding struct {
+	Key         string
+	Destination string
+	Problem     string
+}
+
+// Execute reports modules vendored into destinations which no module block
+// uses, and module blocks using vendored modules terrafile doesn't provide
+func (c *doctorUsageCommand) Execute(_ []string) error {
+	allModules, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+	config, err := selectModules(allModules)
+	if err != nil {
+		return err
+	}
+
+	references, err := findReferences()
+	if err != nil {
+		return fmt.Errorf("failed to scan terraform files for module references due to error: %s", err)
+	}
+
+	unused := unusedModules(config, references)
+	missing := missingModules(allModules, references)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tSTACK\tPROBLEM")
+	for _, finding := range append(unused, missing...) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", finding.Key, finding.Destination, finding.Problem)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	log.Infof("[*] Found %d unused and %d missing module(s)", len(unused), len(missing))
+	if c.Strict && len(unused)+len(missing) > 0 {
+		return fmt.Errorf("found %d unused and %d missing module(s)", len(unused), len(missing))
+	}
+
+	return nil
+}
+
+// unusedModules returns modules of config vendored into a destination where
+// no module block references them
+func unusedModules(config map[string]module, references []moduleReference) []usageFinding {
+	used := make(map[string]bool)
+	for _, r := range references {
+		used[r.Key+"\x00"+filepath.Clean(r.Destination)] = true
+	}
+
+	var findings []usageFinding
+	for _, key := range sortedKeys(config) {
+		destinations := config[key].Destinations
+		if len(destinations) == 0 {
+			destinations = []string{"."}
+		}
+		for _, d := range destinations {
+			if !used[key+"\x00"+filepath.Clean(d)] {
+				findings = append(findings, usageFinding{
+					Key:         key,
+					Destination: d,
+					Problem:     fmt.Sprintf("unused, vendored into %s but no module block references it", filepath.Join(d, opts.ModulePath, key)),
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+// missingModules returns module blocks referencing a vendored module which
+// config doesn't provide in their destination
+func missingModules(config map[string]module, references []moduleReference) []usageFinding {
+	var findings []usageFinding
+	for _, r := range references {
+		m, ok := config[r.Key]
+		switch {
+		case !ok:
+			findings = append(findings, usageFinding{
+				Key:         r.Key,
+				Destination: r.Destination,
+				Problem:     fmt.Sprintf("missing, %s:%d references a module which is not in %s", r.File, r.Line, opts.TerrafilePath),
+			})
+		case !installedInto(m, r.Destination):
+			findings = append(findings, usageFinding{
+				Key:         r.Key,
+				Destination: r.Destination,
+				Problem:     fmt.Sprintf("missing, %s:%d references %s but the module isn't installed there", r.File, r.Line, filepath.Join(r.Destination, opts.ModulePath, r.Key)),
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Key != findings[j].Key {
+			return findings[i].Key < findings[j].Key
+		}
+		return findings[i].Destination < findings[j].Destination
+	})
+
+	return findings
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageFindings(t *testing.T) {
+	defer restoreOpts()()
+	setTestOpts()
+	opts.TerrafilePath = "Terrafile"
+
+	config := map[string]module{
+		"tf-aws-vpc": {Destinations: []string{"stacks/networking", "stacks/legacy"}},
+		"tf-aws-iam": {},
+	}
+	references := []moduleReference{
+		{Key: "tf-aws-vpc", Destination: "stacks/networking", File: "stacks/networking/main.tf", Line: 1},
+		{Key: "tf-aws-vpc", Destination: "stacks/onboarding", File: "stacks/onboarding/main.tf", Line: 3},
+		{Key: "tf-aws-iam", Destination: ".", File: "main.tf", Line: 7},
+		{Key: "tf-aws-s3", Destination: "stacks/networking", File: "stacks/networking/s3.tf", Line: 2},
+	}
+
+	assert.Equal(t, []usageFinding{
+		{Key: "tf-aws-vpc", Destination: "stacks/legacy", Problem: "unused, vendored into stacks/legacy/vendor/modules/tf-aws-vpc but no module block references it"},
+	}, unusedModules(config, references))
+
+	assert.Equal(t, []usageFinding{
+		{Key: "tf-aws-s3", Destination: "stacks/networking", Problem: "missing, stacks/networking/s3.tf:2 references a module which is not in Terrafile"},
+		{Key: "tf-aws-vpc", Destination: "stacks/onboarding", Problem: "missing, stacks/onboarding/main.tf:3 references stacks/onboarding/vendor/modules/tf-aws-vpc but the module isn't installed there"},
+	}, missingModules(config, references))
+}
@@ -0,0 +1,384 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// moduleFields lists every field a module definition may have
+var moduleFields = []string{"source", "version", "destinations", "include", "exclude", "tags", "override", "enabled"}
+
+// moduleKeyPattern matches module names that are safe to use as a folder name
+var moduleKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validationError describes a problem found in the Terrafile with its location
+type validationError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e validationError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+type validateCommand struct{}
+
+// Execute validates the Terrafile without fetching any module
+func (c *validateCommand) Execute(_ []string) error {
+	config, err := loadTerrafile()
+	if err != nil {
+		return err
+	}
+
+	errs := append(checkSources(config), checkPaths(config)...)
+	for _, err := range errs {
+		log.Error(err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s is invalid, found %d error(s)", opts.TerrafilePath, len(errs))
+	}
+
+	log.Infof("[*] %s is valid", opts.TerrafilePath)
+	return nil
+}
+
+// loadTerrafile reads the Terrafile of the run, logging every problem found
+func loadTerrafile() (map[string]module, error) {
+	if err := checkVars(); err != nil {
+		return nil, err
+	}
+
+	// mirrors apply to every file fetched while loading
+	user, errs := readUserConfig()
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		return nil, fmt.Errorf("failed to load user configuration %s due to %d error(s)", userConfigPath(), len(errs))
+	}
+	mirrorRules = user.Mirrors
+
+	l := includeLoader{mirrors: true}
+	config, settings, errs := l.load(terrafileLocation{file: opts.TerrafilePath}, nil)
+	if len(errs) == 0 {
+		// options like the module path are used to find destinations below
+		applyOptionDefaults(settings)
+		errs = applyProfile(config, settings.Profiles, l.catalog)
+	}
+	if len(errs) == 0 {
+		errs = loadOverrides(opts.TerrafilePath, config)
+	}
+	if len(errs) == 0 {
+		errs = expandDestinations(config)
+	}
+	if len(errs) == 0 && settings.AutoDestinations {
+		if err := discoverDestinations(config); err != nil {
+			errs = []error{err}
+		}
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		return nil, fmt.Errorf("failed to load configuration from file %s due to %d error(s)", opts.TerrafilePath, len(errs))
+	}
+
+	// patterns of the ignore file apply to every module, before its own ones
+	ignored, err := readIgnoreFile(opts.TerrafilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s due to error: %s", ignoreFile, err)
+	}
+	if len(ignored) > 0 {
+		for key, m := range config {
+			m.Exclude = append(append([]string{}, ignored...), m.Exclude...)
+			config[key] = m
+		}
+	}
+
+	return config, nil
+}
+
+// readTerrafile reads and strictly decodes the Terrafile, reporting every problem found
+func readTerrafile(filename string) (map[string]module, terrafileSettings, []error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, terrafileSettings{}, []error{fmt.Errorf("failed to read configuration in file %s due to error: %s", filename, err)}
+	}
+
+	return parseTerrafile(filename, data)
+}
+
+// parseTerrafile strictly decodes contents of the Terrafile named filename
+// into its modules and the settings section
+func parseTerrafile(filename string, data []byte) (map[string]module, terrafileSettings, []error) {
+	return decodeTerrafile(filename, data, true)
+}
+
+// decodeTerrafile decodes the Terrafile like parseTerrafile, variables are
+// only replaced if interpolate is set
+func decodeTerrafile(filename string, data []byte, interpolate bool) (map[string]module, terrafileSettings, []error) {
+	var settings terrafileSettings
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, settings, []error{validationError{File: filename, Message: err.Error()}}
+	}
+
+	config := make(map[string]module)
+	// empty file
+	if len(root.Content) == 0 {
+		return config, settings, nil
+	}
+
+	v := validator{file: filename}
+	if interpolate {
+		v.interpolateNode(&root)
+		if len(v.errs) > 0 {
+			return nil, settings, v.errs
+		}
+	}
+
+	v.validateConfig(root.Content[0])
+	if len(v.errs) > 0 {
+		return nil, settings, v.errs
+	}
+
+	// the settings section must not be mistaken for a module
+	if node := takeSection(root.Content[0], settingsKey); node != nil {
+		if err := node.Decode(&settings); err != nil {
+			return nil, settings, []error{validationError{File: filename, Message: err.Error()}}
+		}
+	}
+
+	if err := root.Content[0].Decode(&config); err != nil {
+		return nil, settings, []error{validationError{File: filename, Message: err.Error()}}
+	}
+
+	return config, settings, nil
+}
+
+// validator collects validation errors of a single Terrafile
+type validator struct {
+	file string
+	errs []error
+}
+
+func (v *validator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errs = append(v.errs, validationError{
+		File:    v.file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validateConfig(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping of module names to module definitions")
+		return
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := keyNode.Value
+
+		if line, ok := seen[key]; ok {
+			v.errorf(keyNode, "duplicate module %q, first defined on line %d", key, line)
+			continue
+		}
+		seen[key] = keyNode.Line
+
+		if key == settingsKey {
+			v.validateSettings(valueNode)
+			continue
+		}
+
+		if !moduleKeyPattern.MatchString(key) {
+			v.errorf(keyNode, "invalid module name %q, names must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", key)
+		}
+
+		v.validateModule(key, keyNode, valueNode)
+	}
+}
+
+func (v *validator) validateModule(key string, keyNode *yaml.Node, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "module %q must be a mapping with %s fields", key, strings.Join(moduleFields, ", "))
+		return
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fieldNode, valueNode := node.Content[i], node.Content[i+1]
+		field := fieldNode.Value
+
+		if _, ok := fields[field]; ok {
+			v.errorf(fieldNode, "duplicate field %q in module %q", field, key)
+			continue
+		}
+		fields[field] = valueNode
+
+		if !contains(moduleFields, field) {
+			message := fmt.Sprintf("unknown field %q in module %q", field, key)
+			if suggestion := closest(field, moduleFields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.errorf(fieldNode, "%s", message)
+		}
+	}
+
+	// version may be left out to take it from the catalog
+	for _, field := range []string{"source", "version"} {
+		valueNode, ok := fields[field]
+		switch {
+		case !ok && field == "version":
+		case !ok:
+			v.errorf(keyNode, "module %q is missing required field %q", key, field)
+		case valueNode.Kind != yaml.ScalarNode:
+			v.errorf(valueNode, "field %q of module %q must be a string", field, key)
+		case strings.TrimSpace(valueNode.Value) == "":
+			v.errorf(valueNode, "field %q of module %q must not be empty", field, key)
+		}
+	}
+
+	if valueNode, ok := fields["destinations"]; ok {
+		v.validateDestinations(fmt.Sprintf("module %q", key), valueNode)
+	}
+	for _, field := range []string{"include", "exclude"} {
+		if valueNode, ok := fields[field]; ok {
+			v.validateGlobs(key, field, valueNode)
+		}
+	}
+	if valueNode, ok := fields["tags"]; ok {
+		v.validateTags(key, valueNode)
+	}
+	for _, field := range []string{"override", "enabled"} {
+		if valueNode, ok := fields[field]; ok && (valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!bool") {
+			v.errorf(valueNode, "field %q of module %q must be true or false", field, key)
+		}
+	}
+}
+
+func (v *validator) validateTags(key string, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "field \"tags\" of module %q must be a list of tags", key)
+		return
+	}
+
+	for _, tag := range node.Content {
+		if tag.Kind != yaml.ScalarNode || strings.TrimSpace(tag.Value) == "" || strings.Contains(tag.Value, ",") {
+			v.errorf(tag, "invalid tag %q of module %q, tags must be non-empty strings without commas", tag.Value, key)
+		}
+	}
+}
+
+// validateDestinations checks destinations of owner, a module or the settings section
+func (v *validator) validateDestinations(owner string, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "field \"destinations\" of %s must be a list of paths", owner)
+		return
+	}
+
+	for _, dst := range node.Content {
+		switch {
+		case dst.Kind != yaml.ScalarNode:
+			v.errorf(dst, "destination of %s must be a path", owner)
+		case strings.TrimSpace(dst.Value) == "":
+			v.errorf(dst, "destination of %s must not be empty", owner)
+		case strings.ContainsRune(dst.Value, 0):
+			v.errorf(dst, "destination %q of %s contains a NUL character", dst.Value, owner)
+		case isPattern(dst.Value) && !validGlob(strings.TrimPrefix(dst.Value, "!")):
+			v.errorf(dst, "invalid destination pattern %q of %s", dst.Value, owner)
+		}
+	}
+}
+
+func (v *validator) validateGlobs(key string, field string, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "field %q of module %q must be a list of glob patterns", field, key)
+		return
+	}
+
+	for _, pattern := range node.Content {
+		if pattern.Kind != yaml.ScalarNode || !validGlob(pattern.Value) {
+			v.errorf(pattern, "invalid glob pattern %q in field %q of module %q", pattern.Value, field, key)
+		}
+	}
+}
+
+// closest returns the candidate most similar to name, or an empty string if
+// none of them is close enough to be a likely typo
+func closest(name string, candidates []string) string {
+	best, bestDistance := "", len(name)/2+2
+	for _, candidate := range candidates {
+		if d := levenshtein(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022 IDT Corp.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTerrafile(t *testing.T) {
+	config, _, errs := parseTerrafile("Terrafile", []byte(`tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+  destinations:
+    - networking
+`))
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]module{
+		"tf-aws-vpc": {
+			Source:       "git@github.com:terraform-aws-modules/terraform-aws-vpc",
+			Version:      "v1.46.0",
+			Destinations: []string{"networking"},
+		},
+	}, config)
+}
+
+func TestParseTerrafileErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		yaml     string
+		expected []string
+	}{
+		"unknown field": {
+			yaml: `tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+  destination:
+    - networking
+`,
+			expected: []string{`Terrafile:4:3: unknown field "destination" in module "tf-aws-vpc", did you mean "destinations"?`},
+		},
+		"missing source and empty version": {
+			yaml: `tf-aws-vpc:
+  version: ""
+`,
+			expected: []string{
+				`Terrafile:1:1: module "tf-aws-vpc" is missing required field "source"`,
+				`Terrafile:2:12: field "version" of module "tf-aws-vpc" must not be empty`,
+			},
+		},
+		"duplicate and invalid keys": {
+			yaml: `tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "master"
+../../etc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "master"
+`,
+			expected: []string{
+				`Terrafile:4:1: duplicate module "tf-aws-vpc", first defined on line 1`,
+				`Terrafile:7:1: invalid module name "../../etc", names must start with a letter or digit and contain only letters, digits, '.', '_' and '-'`,
+			},
+		},
+		"bad destinations": {
+			yaml: `tf-aws-vpc:
+  source:  "git@github.com:terraform-aws-modules/terraform-aws-vpc"
+  version: "v1.46.0"
+  destinations:
+    - ""
+    - [networking]
+`,
+			expected: []string{
+				`Terrafile:5:7: destination of module "tf-aws-vpc" must not be empty`,
+				`Terrafile:6:7: destination of module "tf-aws-vpc" must be a path`,
+			},
+		},
+		"not a mapping": {
+			yaml:     "- tf-aws-vpc\n",
+			expected: []string{`Terrafile:1:1: expected a mapping of module names to module definitions`},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, errs := parseTerrafile("Terrafile", []byte(test.yaml))
+			var messages []string
+			for _, err := range errs {
+				messages = append(messages, err.Error())
+			}
+			assert.Equal(t, test.expected, messages)
+		})
+	}
+}
+
+func TestClosest(t *testing.T) {
+	assert.Equal(t, "destinations", closest("destination", moduleFields))
+	assert.Equal(t, "version", closest("verison", moduleFields))
+	assert.Equal(t, "tags", closest("tag", moduleFields))
+	assert.Equal(t, "", closest("ref", moduleFields))
+}